@@ -0,0 +1,292 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package charset transcodes GBK and GB18030 encoded text from and to
+// UTF-8, so that the rest of the code can work with UTF-8 strings
+// regardless of the encoding of the files it reads and writes.
+package charset
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Encoding identifies the character encoding of a file.
+type Encoding int
+
+const (
+	// Auto detects the encoding of the input: it is UTF-8 if the first
+	// DetectionSize bytes are valid UTF-8, and GB18030 otherwise. When
+	// writing, Auto means UTF-8.
+	Auto Encoding = iota
+	UTF8
+	GBK
+	GB18030
+)
+
+// DetectionSize is the number of bytes examined by Auto detection.
+const DetectionSize = 64 * 1024
+
+func (e Encoding) String() string {
+	switch e {
+	case Auto:
+		return "auto"
+	case UTF8:
+		return "utf-8"
+	case GBK:
+		return "gbk"
+	case GB18030:
+		return "gb18030"
+	}
+	return fmt.Sprintf("Encoding(%d)", int(e))
+}
+
+// ParseEncoding returns the Encoding of the given name, which is one of
+// "auto", "utf-8", "gbk" and "gb18030", ignoring case.
+func ParseEncoding(name string) (Encoding, error) {
+	switch strings.ToLower(name) {
+	case "auto", "":
+		return Auto, nil
+	case "utf-8", "utf8":
+		return UTF8, nil
+	case "gbk", "cp936":
+		return GBK, nil
+	case "gb18030":
+		return GB18030, nil
+	}
+	return Auto, errors.New(fmt.Sprintf("unknown encoding [%s]", name))
+}
+
+// Detect guesses the encoding of the given bytes, which are the beginning
+// of a file: UTF-8 if they are valid UTF-8 (a truncated character at the
+// end is allowed), GB18030 otherwise.
+func Detect(prefix []byte) Encoding {
+	for i := 0; i < utf8.UTFMax && len(prefix) > 0; i++ {
+		if utf8.Valid(prefix) {
+			return UTF8
+		}
+		// The prefix may end in the middle of a character.
+		prefix = prefix[:len(prefix)-1]
+	}
+	if len(prefix) == 0 {
+		return UTF8
+	}
+	return GB18030
+}
+
+// NewReader returns a reader producing the UTF-8 transcoding of r, which
+// is encoded in enc. A UTF-8 byte order mark at the beginning is dropped.
+func NewReader(r io.Reader, enc Encoding) io.Reader {
+	br := bufio.NewReaderSize(r, DetectionSize)
+	if enc == Auto {
+		prefix, _ := br.Peek(DetectionSize)
+		enc = Detect(prefix)
+	}
+	switch enc {
+	case GBK, GB18030:
+		return &decoder{reader: br}
+	}
+	if bom, err := br.Peek(3); err == nil && string(bom) == "\xEF\xBB\xBF" {
+		br.Discard(3)
+	}
+	return br
+}
+
+// decoder transcodes GBK/GB18030 to UTF-8. Invalid sequences are
+// replaced by utf8.RuneError.
+type decoder struct {
+	reader  *bufio.Reader
+	pending []byte // decoded bytes not yet returned
+	buf     [utf8.UTFMax]byte
+}
+
+func (d *decoder) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(d.pending) > 0 {
+			c := copy(p[n:], d.pending)
+			d.pending = d.pending[c:]
+			n += c
+			continue
+		}
+		// Only block on the underlying reader if nothing was decoded yet.
+		if n > 0 && d.reader.Buffered() == 0 {
+			break
+		}
+		r, err := d.decodeRune()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		if r < utf8.RuneSelf && n < len(p) {
+			p[n] = byte(r)
+			n++
+		} else {
+			size := utf8.EncodeRune(d.buf[:], r)
+			d.pending = d.buf[:size]
+		}
+	}
+	return n, nil
+}
+
+func (d *decoder) decodeRune() (rune, error) {
+	b1, err := d.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b1 < 0x80 {
+		return rune(b1), nil
+	}
+	if b1 == 0x80 || b1 == 0xFF {
+		return utf8.RuneError, nil
+	}
+	next, _ := d.reader.Peek(3)
+	if len(next) >= 1 && next[0] >= 0x40 && next[0] <= 0xFE && next[0] != 0x7F {
+		d.reader.Discard(1)
+		return decodeTwoByte(b1, next[0]), nil
+	}
+	if len(next) == 3 && next[0] >= 0x30 && next[0] <= 0x39 &&
+		next[1] >= 0x81 && next[1] <= 0xFE && next[2] >= 0x30 && next[2] <= 0x39 {
+		d.reader.Discard(3)
+		return decodeFourByte(b1, next[0], next[1], next[2]), nil
+	}
+	return utf8.RuneError, nil
+}
+
+func decodeTwoByte(b1, b2 byte) rune {
+	index := int(b1-0x81)*190 + int(b2-0x40)
+	if b2 > 0x7F {
+		index--
+	}
+	if r := gbTwoByte[index]; r != 0 {
+		return rune(r)
+	}
+	return utf8.RuneError
+}
+
+// supplementaryStart is the linear index of 0x90 0x30 0x81 0x30, the
+// four-byte sequence of U+10000.
+const supplementaryStart = 189000
+
+func fourByteIndex(b1, b2, b3, b4 byte) uint32 {
+	return ((uint32(b1-0x81)*10+uint32(b2-0x30))*126+uint32(b3-0x81))*10 + uint32(b4-0x30)
+}
+
+func decodeFourByte(b1, b2, b3, b4 byte) rune {
+	index := fourByteIndex(b1, b2, b3, b4)
+	if index >= supplementaryStart {
+		if r := rune(index - supplementaryStart + 0x10000); r <= utf8.MaxRune {
+			return r
+		}
+		return utf8.RuneError
+	}
+	n := len(gbFourByteRanges)
+	i := sort.Search(n, func(i int) bool { return gbFourByteRanges[i][0] > index }) - 1
+	if i < 0 || (i == n-1 && index >= 39420) {
+		return utf8.RuneError
+	}
+	return rune(gbFourByteRanges[i][1] + index - gbFourByteRanges[i][0])
+}
+
+var (
+	encodeOnce  sync.Once
+	encodeTable map[rune]uint16 // code point to two-byte sequence
+)
+
+func initEncodeTable() {
+	encodeTable = make(map[rune]uint16, len(gbTwoByte))
+	for i, r := range gbTwoByte {
+		b1, b2 := 0x81+i/190, 0x40+i%190
+		if b2 >= 0x7F {
+			b2++
+		}
+		if _, found := encodeTable[rune(r)]; r != 0 && !found {
+			encodeTable[rune(r)] = uint16(b1<<8 | b2)
+		}
+	}
+}
+
+// NewWriter returns a writer encoding the UTF-8 text written to it in enc
+// before writing it to w. Characters that cannot be represented in GBK are
+// written as '?'. Invalid UTF-8 is written as is in UTF-8 and as '?'
+// otherwise.
+func NewWriter(w io.Writer, enc Encoding) io.Writer {
+	switch enc {
+	case GBK, GB18030:
+		encodeOnce.Do(initEncodeTable)
+		return &encoder{writer: w, gb18030: enc == GB18030}
+	}
+	return w
+}
+
+type encoder struct {
+	writer  io.Writer
+	gb18030 bool
+	partial []byte // incomplete UTF-8 sequence at the end of the last write
+	out     []byte
+}
+
+func (e *encoder) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(e.partial) > 0 {
+		p = append(e.partial, p...)
+		e.partial = nil
+	}
+	e.out = e.out[:0]
+	for len(p) > 0 {
+		if !utf8.FullRune(p) {
+			e.partial = append([]byte(nil), p...)
+			break
+		}
+		r, size := utf8.DecodeRune(p)
+		p = p[size:]
+		e.out = e.appendRune(e.out, r, size)
+	}
+	if _, err := e.writer.Write(e.out); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (e *encoder) appendRune(out []byte, r rune, size int) []byte {
+	if r < utf8.RuneSelf {
+		return append(out, byte(r))
+	}
+	if r == utf8.RuneError && size == 1 {
+		return append(out, '?')
+	}
+	if c, found := encodeTable[r]; found {
+		return append(out, byte(c>>8), byte(c))
+	}
+	if !e.gb18030 {
+		return append(out, '?')
+	}
+	var index uint32
+	if r >= 0x10000 {
+		index = uint32(r) - 0x10000 + supplementaryStart
+	} else {
+		i := sort.Search(len(gbFourByteRanges), func(i int) bool {
+			return gbFourByteRanges[i][1] > uint32(r)
+		}) - 1
+		if i < 0 {
+			return append(out, '?')
+		}
+		index = gbFourByteRanges[i][0] + uint32(r) - gbFourByteRanges[i][1]
+	}
+	b4 := byte(index%10) + 0x30
+	index /= 10
+	b3 := byte(index%126) + 0x81
+	index /= 126
+	b2 := byte(index%10) + 0x30
+	b1 := byte(index/10) + 0x81
+	return append(out, b1, b2, b3, b4)
+}
@@ -0,0 +1,75 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package charset
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var testCases = []struct {
+	utf8    string
+	gb18030 string
+}{
+	{"0 0.001577 服装 0.128685\n", "0 0.001577 \xb7\xfe\xd7\xb0 0.128685\n"},
+	{"鲜花é", "\xcf\xca\xbb\xa8\xa8\xa6"},
+	{"ÿ😀", "\x81\x30\x8b\x37\x94\x39\xfc\x36"},
+}
+
+func decode(t *testing.T, s string, enc Encoding) string {
+	b, err := ioutil.ReadAll(NewReader(strings.NewReader(s), enc))
+	if err != nil {
+		t.Fatalf("Failed to decode %q: %s", s, err)
+	}
+	return string(b)
+}
+
+func encode(t *testing.T, s string, enc Encoding) string {
+	var b bytes.Buffer
+	w := NewWriter(&b, enc)
+	// Write one byte at a time to exercise incomplete UTF-8 sequences.
+	for i := 0; i < len(s); i++ {
+		if _, err := w.Write([]byte{s[i]}); err != nil {
+			t.Fatalf("Failed to encode %q: %s", s, err)
+		}
+	}
+	return b.String()
+}
+
+func TestGB18030(t *testing.T) {
+	for _, c := range testCases {
+		if s := decode(t, c.gb18030, GB18030); s != c.utf8 {
+			t.Errorf("Expected %q to decode to %q but got %q.", c.gb18030, c.utf8, s)
+		}
+		if s := decode(t, c.gb18030, Auto); s != c.utf8 {
+			t.Errorf("Expected auto detection to decode %q to %q but got %q.", c.gb18030, c.utf8, s)
+		}
+		if s := encode(t, c.utf8, GB18030); s != c.gb18030 {
+			t.Errorf("Expected %q to encode to %q but got %q.", c.utf8, c.gb18030, s)
+		}
+	}
+	if s := encode(t, "鲜花😀", GBK); s != "\xcf\xca\xbb\xa8?" {
+		t.Errorf("Expected characters outside of GBK to be replaced but got %q.", s)
+	}
+	if s := decode(t, "a\x81", GBK); s != "a\uFFFD" {
+		t.Errorf("Expected invalid sequence to be replaced but got %q.", s)
+	}
+}
+
+func TestUTF8(t *testing.T) {
+	if s := decode(t, "\xEF\xBB\xBF鲜花", Auto); s != "鲜花" {
+		t.Errorf("Expected UTF-8 to be detected and the BOM dropped but got %q.", s)
+	}
+	if Detect([]byte("鲜花"[:4])) != UTF8 {
+		t.Errorf("Expected UTF-8 prefix ending in a partial character to be detected as UTF-8.")
+	}
+	for _, name := range []string{"auto", "UTF-8", "gbk", "GB18030"} {
+		if _, err := ParseEncoding(name); err != nil {
+			t.Errorf("ParseEncoding(%s) failed: %s", name, err)
+		}
+	}
+}
@@ -6,9 +6,13 @@ package main
 
 import (
 	"./kmean"
+	"charset"
 	"flag"
 	"fmt"
 	"log"
+	"math/rand"
+	"os"
+	"time"
 )
 
 var (
@@ -16,26 +20,43 @@ var (
 		"Path of the corpus file for doing the clustering.")
 	numCluster = flag.Int("num_cluster", 100, "Number of clusters")
 	output     = flag.String("output", "./cluster_result.txt", "file to store the result")
+	encoding   = flag.String("encoding", "auto", "Encoding of the corpus file: auto, utf-8, gbk or gb18030.")
+	outputEnc  = flag.String("output_encoding", "utf-8", "Encoding of the printed result: utf-8, gbk or gb18030.")
+	seed       = flag.Int64("seed", 0, "Seed of the random choices of kmean++, 0 to derive it from the current time.")
 )
 
 func main() {
+	flag.Parse()
 	var sampleSupplier kmean.PlsaSampleSupplier
-	err := sampleSupplier.Load(*corpus)
+	var err error
+	if sampleSupplier.Encoding, err = charset.ParseEncoding(*encoding); err != nil {
+		log.Fatalf("Error: %s.\n", err)
+	}
+	outEnc, err := charset.ParseEncoding(*outputEnc)
+	if err != nil {
+		log.Fatalf("Error: %s.\n", err)
+	}
+	stdout := charset.NewWriter(os.Stdout, outEnc)
+	err = sampleSupplier.Load(*corpus)
 	if err != nil {
 		log.Printf("Error: failed to load corpus file[%s]: %s.\n", *corpus, err)
 	} else {
-		clusters := kmean.SphericalKMeanCluster(sampleSupplier, *numCluster)
+		if *seed == 0 {
+			*seed = time.Now().UnixNano()
+		}
+		clusters := kmean.SphericalKMeanClusterWithRand(sampleSupplier, *numCluster, rand.New(rand.NewSource(*seed)))
 
 		// Output Result
+		fmt.Fprintf(stdout, "Seed: %d\n\n", *seed)
 		for _, c := range clusters {
 			avg, stdev := c.PairwiseConsineSimStats()
-			fmt.Printf("Cluster Quality: %f\n", c.Quality())
-			fmt.Printf("Cluster size: %d\n", len(c.Members))
-			fmt.Printf("Pairwise Consine Sim Stats:\nAvg:%f, stdev: %f\n", avg, stdev)
+			fmt.Fprintf(stdout, "Cluster Quality: %f\n", c.Quality())
+			fmt.Fprintf(stdout, "Cluster size: %d\n", len(c.Members))
+			fmt.Fprintf(stdout, "Pairwise Consine Sim Stats:\nAvg:%f, stdev: %f\n", avg, stdev)
 			for _, m := range c.Members {
-				fmt.Printf("%v\n", m)
+				fmt.Fprintf(stdout, "%v\n", m)
 			}
-			fmt.Printf("\n..........................\n")
+			fmt.Fprintf(stdout, "\n..........................\n")
 		}
 		/*
 			fmt.Printf("\nInter Cluster Consine Similarity:\nClusterA ClusterB AvgSim StdevSim\n")
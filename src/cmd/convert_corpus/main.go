@@ -0,0 +1,38 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"charset"
+	"flag"
+	"log"
+	"plsa"
+)
+
+var (
+	input        = flag.String("input", "", "Path of the document-word count file, one \"docId word count\" per line.")
+	output       = flag.String("output", "./corpus.bin", "Path of the binary corpus file to write.")
+	docWordSep   = flag.String("doc_word_sep", " ", "Separator between the document id and the word.")
+	wordCountSep = flag.String("word_count_sep", " ", "Separator between the word and the count.")
+	strict       = flag.Bool("strict", false, "Fail on the first invalid line instead of skipping it.")
+	encoding     = flag.String("encoding", "auto", "Encoding of the input file: auto, utf-8, gbk or gb18030.")
+)
+
+func main() {
+	flag.Parse()
+	loader := plsa.NewLineOrientedLoader(plsa.SimpleLineFieldExtractor(*docWordSep, *wordCountSep))
+	loader.Strict = *strict
+	var err error
+	if loader.Encoding, err = charset.ParseEncoding(*encoding); err != nil {
+		log.Fatalf("Error: %s.\n", err)
+	}
+	if err = loader.LoadFromFile(*input); err != nil {
+		log.Fatalf("Error: %s.\n", err)
+	}
+	if err = plsa.WriteBinaryCorpus(loader, *output); err != nil {
+		log.Fatalf("Error: %s.\n", err)
+	}
+	log.Printf("Wrote %d documents and %d words to %s.\n", loader.CorpusSize(), loader.VocabularySize(), *output)
+}
@@ -0,0 +1,36 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"charset"
+	"flag"
+	"log"
+	"plsa"
+)
+
+var (
+	modelFile   = flag.String("model", "./plsa_model.txt", "Path of the trained PLSA model.")
+	topN        = flag.Int("top_n", 100, "Number of representative terms of each topic.")
+	renormalize = flag.Bool("renormalize", false, "Scale the probabilities of the top terms to sum to 1.")
+	output      = flag.String("output", "./z_top_w.dat",
+		"file to store the result, in the format read by cluster_plsa_topic")
+	outputEnc = flag.String("output_encoding", "utf-8", "Encoding of the output file: utf-8, gbk or gb18030.")
+)
+
+func main() {
+	flag.Parse()
+	enc, err := charset.ParseEncoding(*outputEnc)
+	if err != nil {
+		log.Fatalf("Error: %s.\n", err)
+	}
+	model, err := plsa.LoadModelFromFile(*modelFile)
+	if err != nil {
+		log.Fatalf("Error: failed to load model: %s.\n", err)
+	}
+	if err = model.SaveTopWordsToFile(*output, *topN, *renormalize, enc); err != nil {
+		log.Fatalf("Error: failed to write %s: %s.\n", *output, err)
+	}
+}
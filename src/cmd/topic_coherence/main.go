@@ -0,0 +1,107 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"charset"
+	"coherence"
+	"flag"
+	"log"
+	"os"
+	"plsa"
+)
+
+var (
+	modelFile = flag.String("model", "./plsa_model.txt", "Path of the PLSA model to evaluate.")
+	reference = flag.String("reference", "",
+		"Path of the tokenized reference corpus, one document per line.")
+	indexFile = flag.String("index", "",
+		"Path of the co-occurrence index, built from the reference corpus and saved there if it does not exist or was built for other words or another window.")
+	window    = flag.Int("window", 0, "Size of the sliding window, 0 to use whole documents as contexts.")
+	topN      = flag.Int("top_n", 10, "Number of top words of each topic to score.")
+	measure   = flag.String("measure", "uci", "Coherence measure: umass, uci, npmi or c_v.")
+	epsilon   = flag.Float64("epsilon", coherence.DefaultEpsilon, "Smoothing added to co-occurrence probabilities.")
+	output    = flag.String("output", "./topic_coherence.txt", "file to store the result")
+	encoding  = flag.String("encoding", "auto", "Encoding of the reference corpus: auto, utf-8, gbk or gb18030.")
+	outputEnc = flag.String("output_encoding", "utf-8", "Encoding of the output file: utf-8, gbk or gb18030.")
+)
+
+func main() {
+	flag.Parse()
+	var m coherence.Measure
+	found := false
+	for _, c := range coherence.Measures {
+		if c.String() == *measure {
+			m, found = c, true
+		}
+	}
+	if !found {
+		log.Fatalf("Error: unknown coherence measure [%s].\n", *measure)
+	}
+
+	corpusEnc, err := charset.ParseEncoding(*encoding)
+	if err != nil {
+		log.Fatalf("Error: %s.\n", err)
+	}
+	outEnc, err := charset.ParseEncoding(*outputEnc)
+	if err != nil {
+		log.Fatalf("Error: %s.\n", err)
+	}
+
+	model, err := plsa.LoadModelFromFile(*modelFile)
+	if err != nil {
+		log.Fatalf("Error: failed to load model: %s.\n", err)
+	}
+
+	// Only the top words of the topics need to be counted.
+	var words []string
+	for z := 0; z < model.NumberOfTopics(); z++ {
+		topWords, _ := model.TopWords(z, *topN)
+		words = append(words, topWords...)
+	}
+	param := &coherence.IndexParameter{WindowSize: *window, Words: words, Encoding: corpusEnc}
+	var idx *coherence.CooccurrenceIndex
+	if *indexFile != "" {
+		if _, err := os.Stat(*indexFile); err == nil {
+			if idx, err = coherence.LoadCooccurrenceIndexFromFile(*indexFile); err != nil {
+				log.Fatalf("Error: failed to load index: %s.\n", err)
+			}
+			if !idx.Matches(param) {
+				log.Printf("Index [%s] was built for other words or another window, rebuilding it.\n", *indexFile)
+				idx = nil
+			}
+		}
+	}
+	if idx == nil {
+		if *reference == "" {
+			log.Fatalf("Error: no reference corpus to build the index from.\n")
+		}
+		idx, err = coherence.BuildCooccurrenceIndex(*reference, param)
+		if err != nil {
+			log.Fatalf("Error: failed to index reference corpus [%s]: %s.\n", *reference, err)
+		}
+		if *indexFile != "" {
+			if err := idx.SaveToFile(*indexFile); err != nil {
+				log.Printf("Error: failed to save index [%s]: %s.\n", *indexFile, err)
+			}
+		}
+	}
+
+	scorer := coherence.NewScorer(idx)
+	scorer.Epsilon = *epsilon
+	scores := scorer.ScoreModel(model, *topN, m)
+
+	file, err := os.Create(*output)
+	if err != nil {
+		log.Fatalf("Error: failed to create output file [%s]: %s.\n", *output, err)
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(charset.NewWriter(file, outEnc))
+	if err := coherence.WriteTopicScores(writer, m, scores); err != nil {
+		log.Fatalf("Error: failed to write output file [%s]: %s.\n", *output, err)
+	}
+	writer.Flush()
+}
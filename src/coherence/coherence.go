@@ -0,0 +1,259 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package coherence implements topic coherence measures computed from the
+// word and word co-occurrence probabilities of a reference corpus:
+//
+//	UMass: mean over i > j of log((P(wi,wj) + eps) / P(wj))
+//	UCI:   mean over i < j of PMI(wi,wj) = log((P(wi,wj) + eps) / (P(wi)P(wj)))
+//	NPMI:  mean over i < j of PMI(wi,wj) / -log(P(wi,wj) + eps)
+//	C_V:   mean over i of cos(v(wi), v(W)), where v(wi) is the vector of
+//	       sign(NPMI(wi,wj)) |NPMI(wi,wj)|^gamma over all the words wj of the
+//	       topic and v(W) is the sum of v(wi).
+//
+// The words of a topic are expected to be in decreasing order of P(w|z),
+// which matters for UMass only. Pairs involving a word that does not occur
+// in the reference corpus are ignored.
+//
+// Please refer to the following for details:
+//
+//	Optimizing Semantic Coherence in Topic Models by David Mimno et al.
+//	External Evaluation of Topic Models by David Newman, Sarvnaz Karimi, Lawrence Cavedon
+//	Exploring the Space of Topic Coherence Measures by Michael Röder et al.
+package coherence
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"plsa"
+	"sort"
+	"strings"
+)
+
+// Measure identifies a topic coherence measure.
+type Measure int
+
+const (
+	UMass Measure = iota
+	UCI
+	NPMI
+	CV
+)
+
+// Measures lists all the supported coherence measures.
+var Measures = []Measure{UMass, UCI, NPMI, CV}
+
+func (m Measure) String() string {
+	switch m {
+	case UMass:
+		return "umass"
+	case UCI:
+		return "uci"
+	case NPMI:
+		return "npmi"
+	case CV:
+		return "c_v"
+	}
+	return fmt.Sprintf("Measure(%d)", int(m))
+}
+
+// DefaultEpsilon is the smoothing added to co-occurrence probabilities by
+// scorers created with NewScorer.
+const DefaultEpsilon = 1e-12
+
+// Scorer computes the coherence of topics using the probabilities supplied
+// by the embedded WordFrequencyRetriever.
+type Scorer struct {
+	plsa.WordFrequencyRetriever
+	Epsilon float64 // Smoothing added to co-occurrence probabilities to avoid log(0).
+	Gamma   float64 // Exponent applied to the magnitude of NPMI in the context vectors of C_V, 0 means 1.
+}
+
+// NewScorer returns a Scorer using the given retriever with the default
+// smoothing.
+func NewScorer(r plsa.WordFrequencyRetriever) *Scorer {
+	return &Scorer{r, DefaultEpsilon, 1}
+}
+
+// Score computes the coherence of the given words using measure m.
+// NaN is returned if no pair of words could be scored.
+func (s *Scorer) Score(m Measure, words []string) float64 {
+	switch m {
+	case UMass:
+		return s.UMass(words)
+	case UCI:
+		return s.UCI(words)
+	case NPMI:
+		return s.NPMI(words)
+	case CV:
+		return s.CV(words)
+	}
+	panic(fmt.Sprintf("Scorer.Score: unknown measure %v", m))
+}
+
+// UMass computes the UMass coherence of the given words.
+func (s *Scorer) UMass(words []string) float64 {
+	probs := s.wordProbs(words)
+	total, n := float64(0), 0
+	for i := 1; i < len(words); i++ {
+		for j := 0; j < i; j++ {
+			if probs[i] > 0 && probs[j] > 0 {
+				total += math.Log((s.WordCooccurenceProb(words[i], words[j]) + s.Epsilon) / probs[j])
+				n++
+			}
+		}
+	}
+	return mean(total, n)
+}
+
+// UCI computes the UCI coherence, i.e. the average PMI, of the given words.
+func (s *Scorer) UCI(words []string) float64 {
+	return s.pairwise(words, s.pmi)
+}
+
+// NPMI computes the average normalized PMI of the given words.
+func (s *Scorer) NPMI(words []string) float64 {
+	return s.pairwise(words, s.npmi)
+}
+
+// CV computes the C_V coherence of the given words.
+func (s *Scorer) CV(words []string) float64 {
+	probs := s.wordProbs(words)
+	var known []int
+	for i, p := range probs {
+		if p > 0 {
+			known = append(known, i)
+		}
+	}
+	if len(known) < 2 {
+		return math.NaN()
+	}
+	vectors := make([][]float64, len(known))
+	topicVector := make([]float64, len(known))
+	for a, i := range known {
+		vectors[a] = make([]float64, len(known))
+		for b, j := range known {
+			v := float64(1)
+			if i != j {
+				v = s.npmi(words[i], words[j], probs[i], probs[j])
+			}
+			if s.Gamma != 0 && s.Gamma != 1 {
+				// A negative NPMI keeps its sign, math.Pow of a negative
+				// number to a non-integer power is NaN.
+				v = math.Copysign(math.Pow(math.Abs(v), s.Gamma), v)
+			}
+			vectors[a][b] = v
+			topicVector[b] += v
+		}
+	}
+	total := float64(0)
+	for _, v := range vectors {
+		total += cosine(v, topicVector)
+	}
+	return total / float64(len(vectors))
+}
+
+func (s *Scorer) wordProbs(words []string) []float64 {
+	probs := make([]float64, len(words))
+	for i, w := range words {
+		probs[i] = s.WordProb(w)
+	}
+	return probs
+}
+
+// pairwise averages f over all the unordered pairs of known words.
+func (s *Scorer) pairwise(words []string, f func(w1, w2 string, p1, p2 float64) float64) float64 {
+	probs := s.wordProbs(words)
+	total, n := float64(0), 0
+	for i := range words {
+		for j := i + 1; j < len(words); j++ {
+			if probs[i] > 0 && probs[j] > 0 {
+				total += f(words[i], words[j], probs[i], probs[j])
+				n++
+			}
+		}
+	}
+	return mean(total, n)
+}
+
+func (s *Scorer) pmi(w1, w2 string, p1, p2 float64) float64 {
+	return math.Log((s.WordCooccurenceProb(w1, w2) + s.Epsilon) / (p1 * p2))
+}
+
+func (s *Scorer) npmi(w1, w2 string, p1, p2 float64) float64 {
+	p12 := s.WordCooccurenceProb(w1, w2) + s.Epsilon
+	if p12 >= 1 {
+		return 1
+	}
+	return math.Log(p12/(p1*p2)) / -math.Log(p12)
+}
+
+func mean(total float64, n int) float64 {
+	if n == 0 {
+		return math.NaN()
+	}
+	return total / float64(n)
+}
+
+func cosine(a, b []float64) float64 {
+	dot, normA, normB := float64(0), float64(0), float64(0)
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// TopicScore holds the coherence score of one topic.
+type TopicScore struct {
+	TopicId     int
+	Score       float64
+	Words       []string // Top words of the topic the score is computed over.
+	Probability float64  // P(z) of the topic.
+}
+
+// ScoreModel computes the coherence of every topic of the model using
+// measure m over the topN words of each topic.
+func (s *Scorer) ScoreModel(model *plsa.Model, topN int, m Measure) []TopicScore {
+	scores := make([]TopicScore, model.NumberOfTopics())
+	for z := range scores {
+		words, _ := model.TopWords(z, topN)
+		scores[z] = TopicScore{z, s.Score(m, words), words, model.TopicProbability(z)}
+	}
+	return scores
+}
+
+type byScore []TopicScore
+
+func (s byScore) Len() int      { return len(s) }
+func (s byScore) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byScore) Less(i, j int) bool {
+	// Topics that could not be scored come first.
+	if math.IsNaN(s[i].Score) || math.IsNaN(s[j].Score) {
+		return math.IsNaN(s[i].Score) && !math.IsNaN(s[j].Score)
+	}
+	return s[i].Score < s[j].Score
+}
+
+// WriteTopicScores writes the scores as "topic<TAB>measure" header followed
+// by one "topicId<TAB>score<TAB>words<TAB>P(z)" line per topic, in
+// increasing order of score, where words are the top words of the topic
+// separated by spaces.
+func WriteTopicScores(w *bufio.Writer, m Measure, scores []TopicScore) error {
+	sorted := append(byScore(nil), scores...)
+	sort.Stable(sorted)
+	fmt.Fprintf(w, "topic\t%s\n", m)
+	for _, s := range sorted {
+		_, err := fmt.Fprintf(w, "%d\t%f\t%s\t%f\n", s.TopicId, s.Score, strings.Join(s.Words, " "), s.Probability)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
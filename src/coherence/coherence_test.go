@@ -0,0 +1,180 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package coherence
+
+import (
+	"bufio"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testFrequencies struct {
+	word map[string]float64
+	pair map[[2]string]float64
+}
+
+func (f *testFrequencies) WordProb(w string) float64 {
+	return f.word[w]
+}
+
+func (f *testFrequencies) WordCooccurenceProb(w1, w2 string) float64 {
+	if p, found := f.pair[[2]string{w1, w2}]; found {
+		return p
+	}
+	return f.pair[[2]string{w2, w1}]
+}
+
+func Float64Equals(a, b float64) bool {
+	return math.Abs(a-b) < 0.00000001
+}
+
+func testScorer() *Scorer {
+	s := NewScorer(&testFrequencies{
+		word: map[string]float64{"鲜花": 0.2, "玫瑰": 0.1, "游戏": 0.4},
+		pair: map[[2]string]float64{{"鲜花", "玫瑰"}: 0.08, {"鲜花", "游戏"}: 0.02, {"玫瑰", "游戏"}: 0.01},
+	})
+	s.Epsilon = 0
+	return s
+}
+
+func TestPairwiseMeasures(t *testing.T) {
+	s := testScorer()
+	words := []string{"鲜花", "玫瑰", "游戏", "未知"}
+	expectedUCI := (math.Log(0.08/(0.2*0.1)) + math.Log(0.02/(0.2*0.4)) + math.Log(0.01/(0.1*0.4))) / 3
+	if uci := s.UCI(words); !Float64Equals(uci, expectedUCI) {
+		t.Errorf("Expected UCI %f but got %f.", expectedUCI, uci)
+	}
+	expectedNPMI := (math.Log(0.08/(0.2*0.1))/-math.Log(0.08) +
+		math.Log(0.02/(0.2*0.4))/-math.Log(0.02) +
+		math.Log(0.01/(0.1*0.4))/-math.Log(0.01)) / 3
+	if npmi := s.NPMI(words); !Float64Equals(npmi, expectedNPMI) {
+		t.Errorf("Expected NPMI %f but got %f.", expectedNPMI, npmi)
+	}
+	expectedUMass := (math.Log(0.08/0.2) + math.Log(0.02/0.2) + math.Log(0.01/0.1)) / 3
+	if umass := s.UMass(words); !Float64Equals(umass, expectedUMass) {
+		t.Errorf("Expected UMass %f but got %f.", expectedUMass, umass)
+	}
+	if !math.IsNaN(s.UCI([]string{"鲜花", "未知"})) {
+		t.Errorf("Expected NaN when no pair of words can be scored.")
+	}
+}
+
+func TestCV(t *testing.T) {
+	s := testScorer()
+	// With x = NPMI(鲜花, 玫瑰), the context vectors are (1, x) and (x, 1).
+	x := math.Log(0.08/(0.2*0.1)) / -math.Log(0.08)
+	expected := (1 + x) / (math.Sqrt(1+x*x) * math.Sqrt(2))
+	coherent := s.CV([]string{"鲜花", "玫瑰"})
+	if !Float64Equals(coherent, expected) {
+		t.Errorf("Expected C_V %f but got %f.", expected, coherent)
+	}
+	mixed := s.CV([]string{"鲜花", "玫瑰", "游戏"})
+	if mixed >= coherent || mixed <= -1 {
+		t.Errorf("Expected C_V of a mixed topic to be in (-1, %f) but got %f.", coherent, mixed)
+	}
+	s.Gamma = 0.5
+	if v := s.CV([]string{"鲜花", "玫瑰", "游戏"}); math.IsNaN(v) || v >= coherent {
+		t.Errorf("Expected C_V with gamma 0.5 of a mixed topic to be below %f but got %f.", coherent, v)
+	}
+}
+
+func TestCooccurrenceIndex(t *testing.T) {
+	testFile := "coherence_test_corpus.txt"
+	indexFile := "coherence_test_index.txt"
+	defer func() {
+		os.Remove(testFile)
+		os.Remove(indexFile)
+	}()
+	content := "鲜花 玫瑰 快递 游戏\n玫瑰 鲜花\n游戏 动画 游戏"
+	if err := ioutil.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file [%s]: %s", testFile, err)
+	}
+
+	docIdx, err := BuildCooccurrenceIndex(testFile, &IndexParameter{})
+	if err != nil {
+		t.Fatalf("BuildCooccurrenceIndex(%s) failed: %s", testFile, err)
+	}
+	if docIdx.NumberOfContexts() != 3 {
+		t.Errorf("Expected 3 document contexts but got %d.", docIdx.NumberOfContexts())
+	}
+	if p := docIdx.WordProb("游戏"); !Float64Equals(p, 2.0/3) {
+		t.Errorf("Expected P(游戏) to be %f but got %f.", 2.0/3, p)
+	}
+	if p := docIdx.WordCooccurenceProb("玫瑰", "鲜花"); !Float64Equals(p, 2.0/3) {
+		t.Errorf("Expected P(玫瑰, 鲜花) to be %f but got %f.", 2.0/3, p)
+	}
+
+	// Windows: [鲜花 玫瑰] [玫瑰 快递] [快递 游戏] | [玫瑰 鲜花] | [游戏 动画] [动画 游戏]
+	windowIdx, err := BuildCooccurrenceIndex(testFile,
+		&IndexParameter{WindowSize: 2, Words: []string{"鲜花", "玫瑰", "游戏"}})
+	if err != nil {
+		t.Fatalf("BuildCooccurrenceIndex(%s) failed: %s", testFile, err)
+	}
+	if windowIdx.NumberOfContexts() != 6 {
+		t.Errorf("Expected 6 window contexts but got %d.", windowIdx.NumberOfContexts())
+	}
+	if p := windowIdx.WordCooccurenceProb("鲜花", "玫瑰"); !Float64Equals(p, 2.0/6) {
+		t.Errorf("Expected P(鲜花, 玫瑰) to be %f but got %f.", 2.0/6, p)
+	}
+	if p := windowIdx.WordProb("动画"); p != 0 {
+		t.Errorf("Expected words outside of the word set not to be counted but got P(动画) = %f.", p)
+	}
+
+	if err := windowIdx.SaveToFile(indexFile); err != nil {
+		t.Fatalf("CooccurrenceIndex.SaveToFile(%s) failed: %s", indexFile, err)
+	}
+	loaded, err := LoadCooccurrenceIndexFromFile(indexFile)
+	if err != nil {
+		t.Fatalf("LoadCooccurrenceIndexFromFile(%s) failed: %s", indexFile, err)
+	}
+	if !reflect.DeepEqual(loaded, windowIdx) {
+		t.Errorf("Loaded index %v differs from the saved one %v.", loaded, windowIdx)
+	}
+	params := []struct {
+		param   IndexParameter
+		matches bool
+	}{
+		{IndexParameter{WindowSize: 2, Words: []string{"游戏", "鲜花", "玫瑰", "鲜花"}}, true},
+		{IndexParameter{WindowSize: 2, Words: []string{"游戏", "鲜花", "动画"}}, false},
+		{IndexParameter{WindowSize: 3, Words: []string{"鲜花", "玫瑰", "游戏"}}, false},
+	}
+	for _, p := range params {
+		if loaded.Matches(&p.param) != p.matches {
+			t.Errorf("Expected Matches(%v) to be %t.", p.param, p.matches)
+		}
+	}
+	if !docIdx.Matches(&IndexParameter{Words: []string{"动画"}}) {
+		t.Errorf("Expected an index of every word to match any word set.")
+	}
+
+	corrupt := "cooccurrence-index 2\nwindow 2\nwordset *\ncontexts 1\nwords 999999999999\n\"鲜花\" 1\n"
+	ioutil.WriteFile(indexFile, []byte(corrupt), 0644)
+	if _, err := LoadCooccurrenceIndexFromFile(indexFile); err == nil {
+		t.Errorf("Expected an index with a corrupt word count to be rejected.")
+	}
+}
+
+func TestWriteTopicScores(t *testing.T) {
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	scores := []TopicScore{
+		{0, 1.5, []string{"鲜花", "玫瑰"}, 0.25},
+		{1, math.NaN(), []string{"游戏"}, 0.5},
+		{2, -0.5, []string{"快递", "游戏"}, 0.25},
+	}
+	if err := WriteTopicScores(w, UCI, scores); err != nil {
+		t.Fatalf("WriteTopicScores failed: %s", err)
+	}
+	w.Flush()
+	expected := "topic\tuci\n1\tNaN\t游戏\t0.500000\n2\t-0.500000\t快递 游戏\t0.250000\n" +
+		"0\t1.500000\t鲜花 玫瑰\t0.250000\n"
+	if b.String() != expected {
+		t.Errorf("Expected\n%s\nbut got\n%s", expected, b.String())
+	}
+}
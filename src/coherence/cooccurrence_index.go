@@ -0,0 +1,370 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package coherence
+
+import (
+	"bufio"
+	"charset"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IndexParameter holds the parameter for building a CooccurrenceIndex.
+type IndexParameter struct {
+	WindowSize int              // Size of the sliding window, 0 means whole documents are used as contexts.
+	Words      []string         // If not empty, only these words and their pairs are counted.
+	Encoding   charset.Encoding // Encoding of the reference corpus, detected automatically by default.
+}
+
+type wordPair struct {
+	a, b string // a < b
+}
+
+func newWordPair(w1, w2 string) wordPair {
+	if w1 > w2 {
+		w1, w2 = w2, w1
+	}
+	return wordPair{w1, w2}
+}
+
+// CooccurrenceIndex is a WordFrequencyRetriever backed by the number of
+// contexts of a reference corpus in which words and pairs of words occur.
+// A context is either a whole document, or a window of WindowSize
+// consecutive words sliding over the document one word at a time; a
+// document shorter than the window is a single context.
+type CooccurrenceIndex struct {
+	WindowSize  int
+	wordSet     string // hash of the words counted, allWords or unknownWords
+	numContexts uint64
+	wordCount   map[string]uint64
+	pairCount   map[wordPair]uint64
+}
+
+// Values of CooccurrenceIndex.wordSet for indices counting every word, and
+// for indices loaded from format version 1, which does not record the
+// words counted.
+const (
+	allWords     = "*"
+	unknownWords = "?"
+)
+
+func newCooccurrenceIndex(windowSize int, wordSet string) *CooccurrenceIndex {
+	return &CooccurrenceIndex{
+		WindowSize: windowSize,
+		wordSet:    wordSet,
+		wordCount:  make(map[string]uint64),
+		pairCount:  make(map[wordPair]uint64),
+	}
+}
+
+// wordSetHash returns a hash of the set of the given words, or allWords if
+// there is none.
+func wordSetHash(words []string) string {
+	if len(words) == 0 {
+		return allWords
+	}
+	sorted := append([]string(nil), words...)
+	sort.Strings(sorted)
+	h := fnv.New64a()
+	for i, w := range sorted {
+		if i == 0 || w != sorted[i-1] {
+			h.Write([]byte(w))
+			h.Write([]byte{0})
+		}
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// Matches tells whether the index has the window size of param and counts
+// every word of param.Words, that is, whether it gives the same
+// probabilities as an index built with param. A saved index should be
+// rebuilt if it does not match, since words it does not count have
+// probability 0 and are ignored by the coherence measures.
+func (idx *CooccurrenceIndex) Matches(param *IndexParameter) bool {
+	return idx.WindowSize == param.WindowSize &&
+		(idx.wordSet == allWords || idx.wordSet == wordSetHash(param.Words))
+}
+
+// BuildCooccurrenceIndex scans the given tokenized reference corpus, which
+// holds one document per line with words separated by white spaces, and
+// counts the word and word pair occurrences over the contexts.
+func BuildCooccurrenceIndex(corpusFile string, param *IndexParameter) (*CooccurrenceIndex, error) {
+	file, err := os.Open(corpusFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var wordSet map[string]bool
+	if len(param.Words) > 0 {
+		wordSet = make(map[string]bool, len(param.Words))
+		for _, w := range param.Words {
+			wordSet[w] = true
+		}
+	}
+	idx := newCooccurrenceIndex(param.WindowSize, wordSetHash(param.Words))
+	reader := bufio.NewReader(charset.NewReader(file, param.Encoding))
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			idx.addDocument(strings.Fields(line), wordSet)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// addDocument counts the contexts of the given document. Words not in
+// wordSet are not counted, but still take up room in the sliding window.
+func (idx *CooccurrenceIndex) addDocument(tokens []string, wordSet map[string]bool) {
+	if len(tokens) == 0 {
+		return
+	}
+	if idx.WindowSize <= 0 || len(tokens) <= idx.WindowSize {
+		idx.addContext(tokens, wordSet)
+		return
+	}
+	for begin := 0; begin+idx.WindowSize <= len(tokens); begin++ {
+		idx.addContext(tokens[begin:begin+idx.WindowSize], wordSet)
+	}
+}
+
+func (idx *CooccurrenceIndex) addContext(tokens []string, wordSet map[string]bool) {
+	idx.numContexts++
+	var words []string
+	seen := make(map[string]bool)
+	for _, t := range tokens {
+		if !seen[t] && (wordSet == nil || wordSet[t]) {
+			seen[t] = true
+			words = append(words, t)
+		}
+	}
+	for i, w1 := range words {
+		idx.wordCount[w1]++
+		for _, w2 := range words[i+1:] {
+			idx.pairCount[newWordPair(w1, w2)]++
+		}
+	}
+}
+
+// NumberOfContexts returns the number of contexts in the reference corpus.
+func (idx *CooccurrenceIndex) NumberOfContexts() uint64 {
+	return idx.numContexts
+}
+
+// WordProb returns the fraction of contexts containing the given word.
+func (idx *CooccurrenceIndex) WordProb(word string) float64 {
+	if idx.numContexts == 0 {
+		return 0
+	}
+	return float64(idx.wordCount[word]) / float64(idx.numContexts)
+}
+
+// WordCooccurenceProb returns the fraction of contexts containing both
+// of the given words.
+func (idx *CooccurrenceIndex) WordCooccurenceProb(word1, word2 string) float64 {
+	if idx.numContexts == 0 {
+		return 0
+	}
+	if word1 == word2 {
+		return idx.WordProb(word1)
+	}
+	return float64(idx.pairCount[newWordPair(word1, word2)]) / float64(idx.numContexts)
+}
+
+// The index is saved as a line oriented text file:
+//
+//	cooccurrence-index <version>
+//	window <window size>
+//	wordset <hash of the words counted, or * if every word is counted>
+//	contexts <number of contexts>
+//	words <number of words>
+//	<quoted word> <count>         (one line per word)
+//	pairs <number of pairs>
+//	<word index> <word index> <count>  (one line per pair)
+//	end
+const (
+	indexFormatName    = "cooccurrence-index"
+	indexFormatVersion = 2
+)
+
+// SaveToFile saves the index to the given file, so that it can be loaded
+// with LoadCooccurrenceIndexFromFile instead of rescanning the corpus.
+func (idx *CooccurrenceIndex) SaveToFile(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	words := make([]string, 0, len(idx.wordCount))
+	for word := range idx.wordCount {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	wordIndex := make(map[string]int, len(words))
+	fmt.Fprintf(w, "%s %d\n", indexFormatName, indexFormatVersion)
+	fmt.Fprintf(w, "window %d\n", idx.WindowSize)
+	fmt.Fprintf(w, "wordset %s\n", idx.wordSet)
+	fmt.Fprintf(w, "contexts %d\n", idx.numContexts)
+	fmt.Fprintf(w, "words %d\n", len(words))
+	for i, word := range words {
+		wordIndex[word] = i
+		fmt.Fprintf(w, "%s %d\n", strconv.Quote(word), idx.wordCount[word])
+	}
+	pairs := make([][2]int, 0, len(idx.pairCount))
+	for p := range idx.pairCount {
+		pairs = append(pairs, [2]int{wordIndex[p.a], wordIndex[p.b]})
+	}
+	sort.Sort(byIndexPair(pairs))
+	fmt.Fprintf(w, "pairs %d\n", len(pairs))
+	for _, p := range pairs {
+		fmt.Fprintf(w, "%d %d %d\n", p[0], p[1], idx.pairCount[wordPair{words[p[0]], words[p[1]]}])
+	}
+	fmt.Fprintf(w, "end\n")
+	err = w.Flush()
+	if cErr := file.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
+
+type byIndexPair [][2]int
+
+func (s byIndexPair) Len() int      { return len(s) }
+func (s byIndexPair) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byIndexPair) Less(i, j int) bool {
+	return s[i][0] < s[j][0] || (s[i][0] == s[j][0] && s[i][1] < s[j][1])
+}
+
+// LoadCooccurrenceIndexFromFile loads an index saved by SaveToFile.
+func LoadCooccurrenceIndexFromFile(filename string) (*CooccurrenceIndex, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	idx, err := readCooccurrenceIndex(bufio.NewReader(file))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("LoadCooccurrenceIndexFromFile(%s): %s", filename, err))
+	}
+	return idx, nil
+}
+
+func readCooccurrenceIndex(reader *bufio.Reader) (*CooccurrenceIndex, error) {
+	lineNo := 0
+	next := func() ([]string, error) {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil, errors.New(fmt.Sprintf("unexpected end of file after line %d, the file is truncated", lineNo))
+		} else if err != nil && err != io.EOF {
+			return nil, err
+		}
+		lineNo++
+		return strings.Fields(line), nil
+	}
+	header := func(keyword string) (uint64, error) {
+		f, err := next()
+		if err != nil {
+			return 0, err
+		}
+		if len(f) != 2 || f[0] != keyword {
+			return 0, errors.New(fmt.Sprintf("line %d: expected [%s <number>]", lineNo, keyword))
+		}
+		return strconv.ParseUint(f[1], 10, 64)
+	}
+
+	version, err := header(indexFormatName)
+	if err != nil {
+		return nil, err
+	}
+	if version < 1 || version > indexFormatVersion {
+		return nil, errors.New(fmt.Sprintf("unsupported index format version %d, expected 1 to %d", version, indexFormatVersion))
+	}
+	window, err := header("window")
+	if err != nil {
+		return nil, err
+	}
+	wordSet := unknownWords
+	if version > 1 {
+		f, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if len(f) != 2 || f[0] != "wordset" {
+			return nil, errors.New(fmt.Sprintf("line %d: expected [wordset <hash>]", lineNo))
+		}
+		wordSet = f[1]
+	}
+	idx := newCooccurrenceIndex(int(window), wordSet)
+	if idx.numContexts, err = header("contexts"); err != nil {
+		return nil, err
+	}
+	numWords, err := header("words")
+	if err != nil {
+		return nil, err
+	}
+	// The words are appended as they are read, so that a corrupt count
+	// fails at the end of the file instead of allocating memory for it.
+	var words []string
+	for i := uint64(0); i < numWords; i++ {
+		f, err := next()
+		if err != nil {
+			return nil, err
+		}
+		var word string
+		var n uint64
+		if len(f) == 2 {
+			word, err = strconv.Unquote(f[0])
+			if err == nil {
+				n, err = strconv.ParseUint(f[1], 10, 64)
+			}
+		}
+		if len(f) != 2 || err != nil {
+			return nil, errors.New(fmt.Sprintf("line %d: invalid word count", lineNo))
+		}
+		words = append(words, word)
+		idx.wordCount[word] = n
+	}
+	numPairs, err := header("pairs")
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numPairs; i++ {
+		f, err := next()
+		if err != nil {
+			return nil, err
+		}
+		var a, b, n uint64
+		if len(f) == 3 {
+			a, err = strconv.ParseUint(f[0], 10, 64)
+			if err == nil {
+				b, err = strconv.ParseUint(f[1], 10, 64)
+			}
+			if err == nil {
+				n, err = strconv.ParseUint(f[2], 10, 64)
+			}
+		}
+		if len(f) != 3 || err != nil || a >= numWords || b >= numWords {
+			return nil, errors.New(fmt.Sprintf("line %d: invalid pair count", lineNo))
+		}
+		idx.pairCount[newWordPair(words[a], words[b])] = n
+	}
+	if f, err := next(); err != nil {
+		return nil, err
+	} else if len(f) != 1 || f[0] != "end" {
+		return nil, errors.New(fmt.Sprintf("line %d: expected [end]", lineNo))
+	}
+	return idx, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package corpus
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"plsa"
+	"reflect"
+	"testing"
+)
+
+func TestChineseSegmenter(t *testing.T) {
+	s := NewChineseSegmenter(map[string]float64{
+		"鲜花": 10, "快递": 8, "鲜": 2, "花": 3, "快": 5, "递": 1, "网上": 4,
+	})
+	words := s.Tokenize("网上鲜花快递, Flowers 99朵!")
+	expected := []string{"网上", "鲜花", "快递", "flowers", "99", "朵"}
+	if !reflect.DeepEqual(words, expected) {
+		t.Errorf("Expected segmentation %v but got %v.", expected, words)
+	}
+	tokenizer := &WhitespaceTokenizer{Lowercase: true}
+	if words := tokenizer.Tokenize(" Hello, (world)  -- x "); !reflect.DeepEqual(words, []string{"hello", "world", "x"}) {
+		t.Errorf("Unexpected whitespace tokenization %v.", words)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "corpus_test")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	docs := map[string]string{
+		"a.txt":     "the cat sat on the mat",
+		"b/b.txt":   "the dog ate the cat food",
+		"b/c.txt":   "the bird",
+		".hidden":   "ignored",
+		"jsonl.txt": "",
+	}
+	for name, text := range docs {
+		path := filepath.Join(dir, "docs", name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatalf("Failed to create test file [%s]: %s", path, err)
+		}
+	}
+
+	p := &Pipeline{Stopwords: map[string]bool{"on": true}, MinDocFreq: 1, MaxDocRatio: 0.5}
+	c := NewMemoryCorpus(p)
+	if err := c.LoadFromFile(filepath.Join(dir, "docs")); err != nil {
+		t.Fatalf("LoadFromFile failed: %s", err)
+	}
+	if ids := c.CorpusIds(); !reflect.DeepEqual(ids, []string{"a.txt", "b/b.txt", "b/c.txt", "jsonl.txt"}) {
+		t.Errorf("Unexpected document ids %v.", ids)
+	}
+	// "the" appears in 3 of the 4 documents, more than allowed by MaxDocRatio.
+	if c.DocWordCount("a.txt", "the") != 0 || c.DocWordCount("b/b.txt", "cat") != 1 || c.DocWordCount("a.txt", "on") != 0 {
+		t.Errorf("Unexpected counts in a.txt and b/b.txt.")
+	}
+	report := c.Report()
+	if report.Documents != 4 || report.Stopwords != 1 || report.DroppedWords != 1 || report.DroppedTokens != 5 {
+		t.Errorf("Unexpected report: %s.", report)
+	}
+	var _ plsa.DocWordFreqRetriever = c
+	var _ plsa.DocWordIterator = c
+
+	jsonl := filepath.Join(dir, "docs.jsonl")
+	content := `{"id": "d1", "text": "Cat cat dog"}` + "\n\n" + `{"id": "d2", "text": "dog bird"}` + "\n"
+	if err := ioutil.WriteFile(jsonl, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file [%s]: %s", jsonl, err)
+	}
+	c, err = (&Pipeline{MinDocFreq: 2}).Run(&JSONLSource{Filename: jsonl})
+	if err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	if c.VocabularySize() != 1 || c.DocWordCount("d1", "dog") != 1 || c.DocWordCount("d2", "dog") != 1 {
+		t.Errorf("Expected only [dog] to be kept but got %v.", c.Vocabulary())
+	}
+
+	bad := filepath.Join(dir, "bad.jsonl")
+	ioutil.WriteFile(bad, []byte(`{"id": "d1", "text": "x"}`+"\n"+`{"id": "d1", "text": "y"}`+"\n"), 0644)
+	if err := NewMemoryCorpus(&Pipeline{}).LoadFromFile(bad); err == nil {
+		t.Errorf("Expected duplicated document ids to be rejected.")
+	}
+}
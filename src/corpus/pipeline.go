@@ -0,0 +1,268 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package corpus builds document-word counts from raw texts, so that a
+// collection of documents can be fed to plsa.TrainFromData without first
+// producing a count file.
+package corpus
+
+import (
+	"charset"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// Pipeline tokenizes the documents of a DocumentSource, drops stopwords
+// and words with too low or too high a document frequency, and counts the
+// remaining words of each document.
+//
+// The default tokenizer keeps an unsegmented Chinese sentence as a single
+// word. No Chinese dictionary is built in, so Chinese texts need a
+// ChineseSegmenter created by NewChineseSegmenter from a dictionary, such
+// as one read by LoadChineseDictionary.
+type Pipeline struct {
+	Tokenizer   Tokenizer        // Defaults to a lower casing WhitespaceTokenizer.
+	Stopwords   map[string]bool  // Words dropped from every document.
+	MinDocFreq  int              // Words appearing in fewer documents are dropped.
+	MaxDocRatio float64          // Words appearing in a larger fraction of the documents are dropped, unless 0.
+	Encoding    charset.Encoding // Encoding of the files read by MemoryCorpus.LoadFromFile.
+}
+
+// IngestReport summarizes the result of Pipeline.Run.
+type IngestReport struct {
+	Documents     int // Number of documents read.
+	Tokens        int // Number of tokens produced by the tokenizer.
+	Stopwords     int // Number of tokens dropped as stopwords.
+	DroppedWords  int // Number of distinct words dropped by document frequency.
+	DroppedTokens int // Number of tokens of the words dropped by document frequency.
+}
+
+func (r *IngestReport) String() string {
+	return fmt.Sprintf("%d documents, %d tokens, %d stopwords, %d words (%d tokens) dropped by document frequency",
+		r.Documents, r.Tokens, r.Stopwords, r.DroppedWords, r.DroppedTokens)
+}
+
+// Run reads all the documents of source and returns their word counts.
+func (p *Pipeline) Run(source DocumentSource) (*MemoryCorpus, error) {
+	c := NewMemoryCorpus(p)
+	if err := c.load(source); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (p *Pipeline) tokenizer() Tokenizer {
+	if p.Tokenizer == nil {
+		return &WhitespaceTokenizer{Lowercase: true}
+	}
+	return p.Tokenizer
+}
+
+// MemoryCorpus holds the word counts of a collection of documents in
+// memory. It implements plsa.DocWordFreqRetriever and
+// plsa.DocWordIterator.
+type MemoryCorpus struct {
+	pipeline  *Pipeline
+	vocab     []string
+	docIds    []string
+	wordIndex map[string]int32
+	docIndex  map[string]int32
+	docWords  [][]int32  // indices of the words of each document, in increasing order
+	docCounts [][]uint64 // counts of the words in docWords
+	report    IngestReport
+}
+
+// NewMemoryCorpus creates an empty corpus whose LoadFromFile runs the
+// given pipeline.
+func NewMemoryCorpus(pipeline *Pipeline) *MemoryCorpus {
+	c := &MemoryCorpus{pipeline: pipeline}
+	c.reset()
+	return c
+}
+
+func (c *MemoryCorpus) reset() {
+	(*c).vocab = nil
+	(*c).docIds = nil
+	(*c).wordIndex = make(map[string]int32)
+	(*c).docIndex = make(map[string]int32)
+	(*c).docWords = nil
+	(*c).docCounts = nil
+	(*c).report = IngestReport{}
+}
+
+// LoadFromFile replaces the content of the corpus by the documents read
+// from path, which is either a directory read as a DirectorySource or a
+// JSONL file read as a JSONLSource.
+func (c *MemoryCorpus) LoadFromFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	var source DocumentSource
+	if info.IsDir() {
+		source = &DirectorySource{path, c.pipeline.Encoding}
+	} else {
+		source = &JSONLSource{path, c.pipeline.Encoding}
+	}
+	if err := c.load(source); err != nil {
+		return errors.New(fmt.Sprintf("MemoryCorpus.LoadFromFile(%s) failed: %s", path, err))
+	}
+	log.Printf("MemoryCorpus.LoadFromFile(%s): %s", path, &c.report)
+	return nil
+}
+
+func (c *MemoryCorpus) load(source DocumentSource) error {
+	c.reset()
+	tokenizer := c.pipeline.tokenizer()
+	err := source.ForEachDocument(func(docId, text string) error {
+		return c.add(docId, tokenizer.Tokenize(text))
+	})
+	if err != nil {
+		return err
+	}
+	c.filter()
+	return nil
+}
+
+func (c *MemoryCorpus) add(docId string, tokens []string) error {
+	if _, found := c.docIndex[docId]; found {
+		return errors.New(fmt.Sprintf("duplicated document id [%s]", docId))
+	}
+	(*c).docIndex[docId] = int32(len(c.docIds))
+	(*c).docIds = append((*c).docIds, docId)
+	(*c).report.Documents++
+	(*c).report.Tokens += len(tokens)
+
+	counts := make(map[int32]uint64)
+	for _, word := range tokens {
+		if c.pipeline.Stopwords[word] {
+			(*c).report.Stopwords++
+			continue
+		}
+		w, found := c.wordIndex[word]
+		if !found {
+			w = int32(len(c.vocab))
+			(*c).wordIndex[word] = w
+			(*c).vocab = append((*c).vocab, word)
+		}
+		counts[w]++
+	}
+	words := make([]int32, 0, len(counts))
+	for w := range counts {
+		words = append(words, w)
+	}
+	sort.Sort(int32Slice(words))
+	wordCounts := make([]uint64, len(words))
+	for i, w := range words {
+		wordCounts[i] = counts[w]
+	}
+	(*c).docWords = append((*c).docWords, words)
+	(*c).docCounts = append((*c).docCounts, wordCounts)
+	return nil
+}
+
+// filter drops the words whose document frequency is out of the limits of
+// the pipeline and renumbers the remaining ones.
+func (c *MemoryCorpus) filter() {
+	docFreq := make([]int, len(c.vocab))
+	for _, words := range c.docWords {
+		for _, w := range words {
+			docFreq[w]++
+		}
+	}
+	maxDocFreq := len(c.docIds)
+	if c.pipeline.MaxDocRatio > 0 {
+		maxDocFreq = int(c.pipeline.MaxDocRatio * float64(len(c.docIds)))
+	}
+	newIndex := make([]int32, len(c.vocab))
+	var vocab []string
+	for w, word := range c.vocab {
+		if docFreq[w] < c.pipeline.MinDocFreq || docFreq[w] > maxDocFreq {
+			newIndex[w] = -1
+			(*c).report.DroppedWords++
+			delete((*c).wordIndex, word)
+			continue
+		}
+		newIndex[w] = int32(len(vocab))
+		(*c).wordIndex[word] = newIndex[w]
+		vocab = append(vocab, word)
+	}
+	if len(vocab) == len(c.vocab) {
+		return
+	}
+	(*c).vocab = vocab
+	for d, words := range c.docWords {
+		counts := c.docCounts[d]
+		n := 0
+		for i, w := range words {
+			if newIndex[w] < 0 {
+				(*c).report.DroppedTokens += int(counts[i])
+				continue
+			}
+			words[n], counts[n] = newIndex[w], counts[i]
+			n++
+		}
+		(*c).docWords[d] = words[:n]
+		(*c).docCounts[d] = counts[:n]
+	}
+}
+
+// Report returns the summary of the last load of the corpus.
+func (c *MemoryCorpus) Report() *IngestReport {
+	return &(*c).report
+}
+
+func (c *MemoryCorpus) CorpusIds() []string {
+	return (*c).docIds
+}
+
+func (c *MemoryCorpus) CorpusSize() int {
+	return len((*c).docIds)
+}
+
+func (c *MemoryCorpus) Vocabulary() []string {
+	return (*c).vocab
+}
+
+func (c *MemoryCorpus) VocabularySize() int {
+	return len((*c).vocab)
+}
+
+func (c *MemoryCorpus) DocWordCount(docId, word string) uint64 {
+	d, found := c.docIndex[docId]
+	if !found {
+		return 0
+	}
+	w, found := c.wordIndex[word]
+	if !found {
+		return 0
+	}
+	words := c.docWords[d]
+	i := sort.Search(len(words), func(i int) bool { return words[i] >= w })
+	if i < len(words) && words[i] == w {
+		return c.docCounts[d][i]
+	}
+	return 0
+}
+
+// ForEachWordInDoc calls processor with each word of the given document
+// in the order of the vocabulary.
+func (c *MemoryCorpus) ForEachWordInDoc(docId string, processor func(word string, count uint64)) {
+	d, found := c.docIndex[docId]
+	if !found {
+		return
+	}
+	for i, w := range c.docWords[d] {
+		processor(c.vocab[w], c.docCounts[d][i])
+	}
+}
+
+type int32Slice []int32
+
+func (s int32Slice) Len() int           { return len(s) }
+func (s int32Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s int32Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
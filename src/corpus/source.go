@@ -0,0 +1,109 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package corpus
+
+import (
+	"charset"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DocumentSource provides the raw texts of a collection of documents.
+type DocumentSource interface {
+	// ForEachDocument calls processor with the id and the UTF-8 text of
+	// each document, stopping at the first error.
+	ForEachDocument(processor func(docId, text string) error) error
+}
+
+// DirectorySource reads each regular file under Dir, recursively, as a
+// document whose id is the path of the file relative to Dir, using "/" as
+// the separator. Files are visited in lexical order and files whose name
+// starts with "." are ignored.
+type DirectorySource struct {
+	Dir      string
+	Encoding charset.Encoding
+}
+
+func (s *DirectorySource) ForEachDocument(processor func(docId, text string) error) error {
+	var paths []string
+	err := filepath.Walk(s.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != s.Dir && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		docId, err := filepath.Rel(s.Dir, path)
+		if err != nil {
+			return err
+		}
+		text, err := readFile(path, s.Encoding)
+		if err != nil {
+			return err
+		}
+		if err := processor(filepath.ToSlash(docId), text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(filename string, enc charset.Encoding) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	content, err := ioutil.ReadAll(charset.NewReader(file, enc))
+	return string(content), err
+}
+
+// JSONLSource reads documents from a file holding one JSON object per
+// line, with the document id in its "id" field and the text in its "text"
+// field. Blank lines are ignored.
+type JSONLSource struct {
+	Filename string
+	Encoding charset.Encoding
+}
+
+type jsonDocument struct {
+	Id   *string `json:"id"`
+	Text *string `json:"text"`
+}
+
+func (s *JSONLSource) ForEachDocument(processor func(docId, text string) error) error {
+	return forEachLine(s.Filename, s.Encoding, func(lineNo int, line string) error {
+		if strings.TrimSpace(line) == "" {
+			return nil
+		}
+		var doc jsonDocument
+		if err := json.Unmarshal([]byte(line), &doc); err != nil {
+			return errors.New(fmt.Sprintf("line %d: %s", lineNo, err))
+		}
+		if doc.Id == nil || doc.Text == nil {
+			return errors.New(fmt.Sprintf("line %d: missing \"id\" or \"text\" field", lineNo))
+		}
+		return processor(*doc.Id, *doc.Text)
+	})
+}
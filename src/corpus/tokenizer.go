@@ -0,0 +1,189 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package corpus
+
+import (
+	"bufio"
+	"charset"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Tokenizer splits a text into words.
+type Tokenizer interface {
+	Tokenize(text string) []string
+}
+
+// WhitespaceTokenizer splits texts at white spaces and strips the
+// punctuation and symbols surrounding each word.
+type WhitespaceTokenizer struct {
+	Lowercase bool // Whether words are converted to lower case.
+}
+
+func (t *WhitespaceTokenizer) Tokenize(text string) []string {
+	var words []string
+	for _, f := range strings.Fields(text) {
+		f = strings.TrimFunc(f, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if f == "" {
+			continue
+		}
+		if t.Lowercase {
+			f = strings.ToLower(f)
+		}
+		words = append(words, f)
+	}
+	return words
+}
+
+// ChineseSegmenter segments texts into words using a dictionary.
+// Each run of Han characters is segmented into the sequence of dictionary
+// words having the highest probability under a unigram model of the word
+// frequencies, where characters not covered by any dictionary word are
+// single character words. Runs of letters and digits of other scripts
+// are words on their own, lower cased; everything else separates words.
+// The dictionary must be supplied, none comes with the package.
+type ChineseSegmenter struct {
+	logProb    map[string]float64 // log probability of each dictionary word
+	maxLength  int                // maximum length of a dictionary word in runes
+	unknownLog float64            // log probability of an unknown single character
+}
+
+// NewChineseSegmenter creates a segmenter from the given dictionary words
+// and their frequencies. Words without a positive frequency are given a
+// frequency of 1.
+func NewChineseSegmenter(words map[string]float64) *ChineseSegmenter {
+	s := &ChineseSegmenter{logProb: make(map[string]float64, len(words))}
+	total := float64(0)
+	for _, f := range words {
+		total += math.Max(f, 1)
+	}
+	total++ // room for unknown characters
+	for w, f := range words {
+		s.logProb[w] = math.Log(math.Max(f, 1) / total)
+		if n := len([]rune(w)); n > s.maxLength {
+			s.maxLength = n
+		}
+	}
+	s.unknownLog = math.Log(1 / total)
+	return s
+}
+
+// LoadChineseDictionary loads a dictionary file holding one word per line,
+// optionally followed by its frequency and other fields separated by
+// white spaces, as in the dictionaries of jieba.
+func LoadChineseDictionary(filename string, enc charset.Encoding) (map[string]float64, error) {
+	words := make(map[string]float64)
+	err := forEachLine(filename, enc, func(lineNo int, line string) error {
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			return nil
+		}
+		freq := float64(1)
+		if len(f) > 1 {
+			var err error
+			if freq, err = strconv.ParseFloat(f[1], 64); err != nil {
+				return errors.New(fmt.Sprintf("line %d: invalid frequency [%s]", lineNo, f[1]))
+			}
+		}
+		words[f[0]] += freq
+		return nil
+	})
+	return words, err
+}
+
+func (s *ChineseSegmenter) Tokenize(text string) []string {
+	var words []string
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		j := i + 1
+		switch {
+		case unicode.Is(unicode.Han, r):
+			for j < len(runes) && unicode.Is(unicode.Han, runes[j]) {
+				j++
+			}
+			words = append(words, s.segment(runes[i:j])...)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			for j < len(runes) && !unicode.Is(unicode.Han, runes[j]) &&
+				(unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+				j++
+			}
+			words = append(words, strings.ToLower(string(runes[i:j])))
+		}
+		i = j
+	}
+	return words
+}
+
+// segment finds the most probable segmentation of a run of Han characters
+// by dynamic programming from the end of the run.
+func (s *ChineseSegmenter) segment(runes []rune) []string {
+	n := len(runes)
+	best := make([]float64, n+1) // best log probability of runes[i:]
+	next := make([]int, n+1)     // end of the first word of the best segmentation of runes[i:]
+	for i := n - 1; i >= 0; i-- {
+		best[i] = s.unknownLog + best[i+1]
+		next[i] = i + 1
+		for j := i + 2; j <= n && j-i <= s.maxLength; j++ {
+			if p, found := s.logProb[string(runes[i:j])]; found && p+best[j] > best[i] {
+				best[i] = p + best[j]
+				next[i] = j
+			}
+		}
+		if p, found := s.logProb[string(runes[i:i+1])]; found && p+best[i+1] > best[i] {
+			best[i] = p + best[i+1]
+			next[i] = i + 1
+		}
+	}
+	var words []string
+	for i := 0; i < n; i = next[i] {
+		words = append(words, string(runes[i:next[i]]))
+	}
+	return words
+}
+
+// LoadStopwords loads a stopword list holding one word per line.
+func LoadStopwords(filename string, enc charset.Encoding) (map[string]bool, error) {
+	stopwords := make(map[string]bool)
+	err := forEachLine(filename, enc, func(lineNo int, line string) error {
+		if w := strings.TrimSpace(line); w != "" {
+			stopwords[w] = true
+		}
+		return nil
+	})
+	return stopwords, err
+}
+
+// forEachLine calls processor with each line of the given file, including
+// the last one if it does not end with a new line.
+func forEachLine(filename string, enc charset.Encoding, processor func(lineNo int, line string) error) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(charset.NewReader(file, enc))
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			if pErr := processor(lineNo, strings.TrimRight(line, "\r\n")); pErr != nil {
+				return errors.New(fmt.Sprintf("%s: %s", filename, pErr))
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
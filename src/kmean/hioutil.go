@@ -7,6 +7,7 @@ package kmean
 
 import (
 	"bufio"
+	"charset"
 	"os"
 	"strings"
 )
@@ -17,6 +18,14 @@ import (
 // When failed to open the given file, error will be returned,
 // otherwise, the return value of processor will be returned.
 func WithOpenFileAsBufioReader(filename string,
+	processor func(*bufio.Reader) error) error {
+	return WithOpenEncodedFileAsBufioReader(filename, charset.UTF8, processor)
+}
+
+// Function WithOpenEncodedFileAsBufioReader is the same as
+// WithOpenFileAsBufioReader, except that the file content is
+// transcoded from the given encoding to UTF-8.
+func WithOpenEncodedFileAsBufioReader(filename string, enc charset.Encoding,
 	processor func(*bufio.Reader) error) error {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -25,12 +34,19 @@ func WithOpenFileAsBufioReader(filename string,
 	defer func() {
 		file.Close()
 	}()
-	return processor(bufio.NewReader(file))
+	return processor(bufio.NewReader(charset.NewReader(file, enc)))
 }
 
 // Function ForEachLineInFile attempts to open the given file and
 // process each line by invoking processor function with the line.
 func ForEachLineInFile(filename string,
+	processor func(line string) (bool, error)) error {
+	return ForEachLineInEncodedFile(filename, charset.UTF8, processor)
+}
+
+// Function ForEachLineInEncodedFile is the same as ForEachLineInFile,
+// except that the lines are transcoded from the given encoding to UTF-8.
+func ForEachLineInEncodedFile(filename string, enc charset.Encoding,
 	processor func(line string) (bool, error)) error {
 	fileProcessor := func(reader *bufio.Reader) error {
 		for {
@@ -45,7 +61,7 @@ func ForEachLineInFile(filename string,
 		}
 		return nil
 	}
-	return WithOpenFileAsBufioReader(filename, fileProcessor)
+	return WithOpenEncodedFileAsBufioReader(filename, enc, fileProcessor)
 }
 
 // Function WithNewOpenFileAsBufioWriter attempts to create a
@@ -54,12 +70,20 @@ func ForEachLineInFile(filename string,
 // If the file failed to create, error will be returned; else,
 // the return value of the processor function will be returned.
 func WithNewOpenFileAsBufioWriter(filename string,
+	processor func(*bufio.Writer) error) error {
+	return WithNewOpenEncodedFileAsBufioWriter(filename, charset.UTF8, processor)
+}
+
+// Function WithNewOpenEncodedFileAsBufioWriter is the same as
+// WithNewOpenFileAsBufioWriter, except that the UTF-8 text written
+// is transcoded to the given encoding.
+func WithNewOpenEncodedFileAsBufioWriter(filename string, enc charset.Encoding,
 	processor func(*bufio.Writer) error) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
-	writer := bufio.NewWriter(file)
+	writer := bufio.NewWriter(charset.NewWriter(file, enc))
 	defer func() {
 		writer.Flush()
 		file.Sync()
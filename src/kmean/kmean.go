@@ -29,10 +29,6 @@ import (
 	"time"
 )
 
-func init() {
-	rand.Seed(time.Now().Unix())
-}
-
 // Interface SampleContainer represents one data sample.
 type SampleContainer interface {
 	Id() int
@@ -59,25 +55,45 @@ type indexDist struct {
 }
 
 // Function KMeanCluster clusters the given sample into k clusters.
+// The initial centers are chosen using a random source seeded with the
+// current time, use KMeanClusterWithRand for reproducible results.
 func KMeanCluster(s SampleSupplier, k int) []Cluster {
+	return KMeanClusterWithRand(s, k, newTimeSeededRand())
+}
+
+// Function KMeanClusterWithRand is the same as KMeanCluster, except that
+// the random choices of kmean++ are drawn from r, so that two runs using
+// sources of the same seed give the same clusters.
+func KMeanClusterWithRand(s SampleSupplier, k int, r *rand.Rand) []Cluster {
 	//Use kmean++ to select the k initial centers.
-	clusters := kMeanPlusPlus(s, k, false)
+	clusters := kMeanPlusPlus(s, k, false, r)
 	// Use kmean to adjust the clusters till no re-assignment has been made.
 	return kMean(s, clusters, false)
 }
 
 // Function SphericalKMeanCluster clusters the given sample into k clusters using
-// the spherical kmeans algorithm
+// the spherical kmeans algorithm, with a random source seeded with the
+// current time.
 func SphericalKMeanCluster(s SampleSupplier, k int) []Cluster {
+	return SphericalKMeanClusterWithRand(s, k, newTimeSeededRand())
+}
+
+// Function SphericalKMeanClusterWithRand is the same as SphericalKMeanCluster,
+// except that the random choices are drawn from r.
+func SphericalKMeanClusterWithRand(s SampleSupplier, k int, r *rand.Rand) []Cluster {
 	// Normalize all samples
 	for i := 0; i < s.SampleSize(); i++ {
 		s.Sample(i).Normalize()
 	}
 	//Use kmean++ to select the k initial centers.
-	clusters := kMeanPlusPlus(s, k, false)
+	clusters := kMeanPlusPlus(s, k, false, r)
 	return kMean(s, clusters, true)
 }
 
+func newTimeSeededRand() *rand.Rand {
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
+
 func normalizeIndexDist(indexD []indexDist) []indexDist {
 	for j, _ := range indexD {
 		if j > 0 {
@@ -91,13 +107,13 @@ func normalizeIndexDist(indexD []indexDist) []indexDist {
 	return indexD
 }
 
-func kMeanPlusPlus(s SampleSupplier, k int, isSpherical bool) []Cluster {
+func kMeanPlusPlus(s SampleSupplier, k int, isSpherical bool, r *rand.Rand) []Cluster {
 	var clusters []Cluster
 	indList := make(map[int]bool)
 	var ind int
 	for i := 1; i <= k; i++ {
 		if i == 1 {
-			ind = rand.Int() % s.SampleSize()
+			ind = r.Intn(s.SampleSize())
 		} else {
 			var indexD []indexDist
 			for sIndex := 0; sIndex < s.SampleSize(); sIndex++ {
@@ -119,7 +135,7 @@ func kMeanPlusPlus(s SampleSupplier, k int, isSpherical bool) []Cluster {
 				}
 			}
 			indexD = normalizeIndexDist(indexD)
-			newProb := rand.Float64()
+			newProb := r.Float64()
 			for _, v := range indexD {
 				if v.dist > newProb {
 					ind = v.index
@@ -5,6 +5,7 @@
 package kmean
 
 import (
+	"charset"
 	"fmt"
 	"log"
 	"math"
@@ -154,11 +155,12 @@ func (s *PlsaSample) CosineSim(c SampleContainer) float64 {
 }
 
 type PlsaSampleSupplier struct {
-	samples []PlsaSample
+	Encoding charset.Encoding // Encoding of the files to load, detected automatically by default.
+	samples  []PlsaSample
 }
 
 func (sp *PlsaSampleSupplier) Load(filename string) error {
-	return ForEachLineInFile(filename, func(line string) (bool, error) {
+	return ForEachLineInEncodedFile(filename, sp.Encoding, func(line string) (bool, error) {
 		fields := strings.Split(line, " ")
 		if len(fields) < 4 {
 			log.Printf("Invalid line: %s", line)
@@ -6,8 +6,11 @@ package kmean
 
 import (
 	"bufio"
+	"charset"
 	"math"
+	"math/rand"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -121,3 +124,61 @@ func TestPlsaSampleSupplier(t *testing.T) {
 		}
 	}
 }
+
+func TestPlsaSampleSupplierEncoding(t *testing.T) {
+	testFile := "kmean_test_gbk.txt"
+	defer func() {
+		os.Remove(testFile)
+	}()
+	err := WithNewOpenEncodedFileAsBufioWriter(testFile, charset.GBK, func(w *bufio.Writer) error {
+		w.WriteString("3 0.1 鲜花 0.1 玫瑰 0.2\n")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test file [%s] : %s", testFile, err)
+	}
+	for _, enc := range []charset.Encoding{charset.Auto, charset.GBK} {
+		supplier := PlsaSampleSupplier{Encoding: enc}
+		if err := supplier.Load(testFile); err != nil {
+			t.Fatalf("PlsaSampleSupplier.Load(%s) failed: %s", testFile, err)
+		}
+		s0 := AssertAsPlsaSample(supplier.Sample(0))
+		if !Float64Equals(s0.repTerms["鲜花"], 0.1) || !Float64Equals(s0.repTerms["玫瑰"], 0.2) {
+			t.Errorf("GBK sample did not load correctly with encoding %s: %v.", enc, s0)
+		}
+	}
+
+	// The shipped topic files are GBK encoded.
+	var shipped PlsaSampleSupplier
+	shippedFile := "../../data/top_rep_terms/20W_z_top_w_top10.dat"
+	if err := shipped.Load(shippedFile); err != nil {
+		t.Fatalf("PlsaSampleSupplier.Load(%s) failed: %s", shippedFile, err)
+	}
+	if s0 := AssertAsPlsaSample(shipped.Sample(0)); !Float64Equals(s0.repTerms["服装"], 0.128685) {
+		t.Errorf("Expected term 服装 in topic 0 of %s but got %v.", shippedFile, s0)
+	}
+}
+
+func TestSeededClustering(t *testing.T) {
+	var samples PlsaSampleSupplier
+	shippedFile := "../../data/top_rep_terms/20W_z_top_w_top10.dat"
+	if err := samples.Load(shippedFile); err != nil {
+		t.Fatalf("PlsaSampleSupplier.Load(%s) failed: %s", shippedFile, err)
+	}
+	members := func(clusters []Cluster) [][]int {
+		var ids [][]int
+		for _, c := range clusters {
+			var m []int
+			for _, s := range c.Members {
+				m = append(m, s.Id())
+			}
+			ids = append(ids, m)
+		}
+		return ids
+	}
+	c1 := members(SphericalKMeanClusterWithRand(samples, 5, rand.New(rand.NewSource(7))))
+	c2 := members(SphericalKMeanClusterWithRand(samples, 5, rand.New(rand.NewSource(7))))
+	if !reflect.DeepEqual(c1, c2) {
+		t.Errorf("Expected clustering with the same seed to give the same clusters.")
+	}
+}
@@ -0,0 +1,418 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+)
+
+// The binary corpus format stores the document-word counts so that they
+// can be used directly from a memory mapping of the file. All integers are
+// little endian. The file consists of:
+//
+//	magic           8 bytes, "plsacsr\x01"
+//	numDocs         uint64
+//	numWords        uint64
+//	numNonZeros     uint64
+//	docIdBytes      uint64, size of the document id strings
+//	vocabBytes      uint64, size of the word strings
+//	docStart        [numDocs+1]uint64, CSR row offsets into wordIds and counts
+//	docIdStart      [numDocs+1]uint64, offsets of the document ids in docIdData
+//	wordStart       [numWords+1]uint64, offsets of the words in vocabData
+//	wordIds         [numNonZeros]uint32, word indices, increasing within a document
+//	counts          [numNonZeros]uint32
+//	docIdOrder      [numDocs]uint32, document indices sorted by document id
+//	wordOrder       [numWords]uint32, word indices sorted by word
+//	docIdData       [docIdBytes]byte
+//	vocabData       [vocabBytes]byte
+const binaryCorpusMagic = "plsacsr\x01"
+
+const binaryCorpusHeaderSize = 8 + 5*8
+
+// WriteBinaryCorpus writes the document-word counts of docWordFreq to the
+// given file in the binary corpus format read by MappedCorpus. Counts must
+// fit in 32 bits.
+func WriteBinaryCorpus(docWordFreq DocWordFreqRetriever, filename string) error {
+	docIds := docWordFreq.CorpusIds()
+	vocab := docWordFreq.Vocabulary()
+	wordIndex := make(map[string]uint32, len(vocab))
+	for i, w := range vocab {
+		wordIndex[w] = uint32(i)
+	}
+	if uint64(len(docIds)) > math.MaxUint32 || uint64(len(vocab)) > math.MaxUint32 {
+		return errors.New(fmt.Sprintf("WriteBinaryCorpus(%s) failed: too many documents or words", filename))
+	}
+
+	docStart := make([]uint64, 1, len(docIds)+1)
+	var wordIds, counts []uint32
+	var err error
+	for _, d := range docIds {
+		begin := len(wordIds)
+		forEachWordInDoc(docWordFreq, d, func(word string, count uint64) {
+			if count == 0 || err != nil {
+				return
+			}
+			w, found := wordIndex[word]
+			if !found {
+				return
+			}
+			if count > math.MaxUint32 {
+				err = errors.New(fmt.Sprintf("count of (%s, %s) does not fit in 32 bits", d, word))
+				return
+			}
+			wordIds = append(wordIds, w)
+			counts = append(counts, uint32(count))
+		})
+		if err != nil {
+			return errors.New(fmt.Sprintf("WriteBinaryCorpus(%s) failed: %s", filename, err))
+		}
+		sort.Sort(&docWords{wordIds[begin:], counts[begin:]})
+		docStart = append(docStart, uint64(len(wordIds)))
+	}
+
+	fd, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fd)
+	if err = writeBinaryCorpus(w, docIds, vocab, docStart, wordIds, counts); err == nil {
+		err = w.Flush()
+	}
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("WriteBinaryCorpus(%s) failed: %s", filename, err))
+	}
+	return nil
+}
+
+// docWords sorts the words of a document by index.
+type docWords struct {
+	wordIds, counts []uint32
+}
+
+func (s *docWords) Len() int           { return len(s.wordIds) }
+func (s *docWords) Less(i, j int) bool { return s.wordIds[i] < s.wordIds[j] }
+func (s *docWords) Swap(i, j int) {
+	s.wordIds[i], s.wordIds[j] = s.wordIds[j], s.wordIds[i]
+	s.counts[i], s.counts[j] = s.counts[j], s.counts[i]
+}
+
+func writeBinaryCorpus(w *bufio.Writer, docIds, vocab []string, docStart []uint64, wordIds, counts []uint32) error {
+	docIdStart, docIdBytes := stringOffsets(docIds)
+	wordStart, vocabBytes := stringOffsets(vocab)
+	w.WriteString(binaryCorpusMagic)
+	header := []uint64{uint64(len(docIds)), uint64(len(vocab)), uint64(len(wordIds)), docIdBytes, vocabBytes}
+	for _, arr := range [][]uint64{header, docStart, docIdStart, wordStart} {
+		if err := binary.Write(w, binary.LittleEndian, arr); err != nil {
+			return err
+		}
+	}
+	for _, arr := range [][]uint32{wordIds, counts, sortedOrder(docIds), sortedOrder(vocab)} {
+		if err := binary.Write(w, binary.LittleEndian, arr); err != nil {
+			return err
+		}
+	}
+	for _, strs := range [][]string{docIds, vocab} {
+		for _, s := range strs {
+			if _, err := w.WriteString(s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func stringOffsets(strs []string) ([]uint64, uint64) {
+	offsets := make([]uint64, len(strs)+1)
+	for i, s := range strs {
+		offsets[i+1] = offsets[i] + uint64(len(s))
+	}
+	return offsets, offsets[len(strs)]
+}
+
+func sortedOrder(strs []string) []uint32 {
+	order := make([]uint32, len(strs))
+	for i := range order {
+		order[i] = uint32(i)
+	}
+	sort.Slice(order, func(i, j int) bool { return strs[order[i]] < strs[order[j]] })
+	return order
+}
+
+// MappedCorpus is a DocWordFreqRetriever reading a file in the binary
+// corpus format written by WriteBinaryCorpus. The file is memory-mapped
+// where the platform supports it, so loading does not depend on the size
+// of the corpus and the pages are shared between processes reading the
+// same file. Document ids and words are looked up by binary search, and
+// the slices returned by CorpusIds and Vocabulary are only built on their
+// first call.
+//
+// LoadFromFile only checks the header and the size of the file. The other
+// methods may panic or give wrong counts on a corrupted file, so files that
+// may be damaged should be checked with Verify, which reads all of them.
+type MappedCorpus struct {
+	data       []byte
+	unmap      func() error
+	numDocs    int
+	numWords   int
+	docStart   []byte // sections of data, decoded on access
+	docIdStart []byte
+	wordStart  []byte
+	wordIds    []byte
+	counts     []byte
+	docIdOrder []byte
+	wordOrder  []byte
+	docIdData  []byte
+	vocabData  []byte
+	docIdsOnce sync.Once
+	docIds     []string
+	vocabOnce  sync.Once
+	vocab      []string
+}
+
+func NewMappedCorpus() *MappedCorpus {
+	return &MappedCorpus{}
+}
+
+// LoadFromFile maps the given binary corpus file, releasing any previously
+// loaded one.
+func (c *MappedCorpus) LoadFromFile(filename string) error {
+	if err := c.Close(); err != nil {
+		return err
+	}
+	data, unmap, err := mapFile(filename)
+	if err != nil {
+		return errors.New(fmt.Sprintf("MappedCorpus.LoadFromFile(%s) failed: %s", filename, err))
+	}
+	*c = MappedCorpus{data: data, unmap: unmap}
+	if err := c.parse(); err != nil {
+		c.Close()
+		return errors.New(fmt.Sprintf("MappedCorpus.LoadFromFile(%s) failed: %s", filename, err))
+	}
+	return nil
+}
+
+// Close releases the mapping of the file. The corpus is empty afterwards.
+func (c *MappedCorpus) Close() error {
+	var err error
+	if c.unmap != nil {
+		err = c.unmap()
+	}
+	*c = MappedCorpus{}
+	return err
+}
+
+func (c *MappedCorpus) parse() error {
+	data := c.data
+	if len(data) < binaryCorpusHeaderSize || !bytes.Equal(data[:8], []byte(binaryCorpusMagic)) {
+		return errors.New("not a binary corpus file")
+	}
+	header := make([]uint64, 5)
+	for i := range header {
+		header[i] = binary.LittleEndian.Uint64(data[8+8*i:])
+	}
+	numDocs, numWords, numNonZeros, docIdBytes, vocabBytes := header[0], header[1], header[2], header[3], header[4]
+	if numDocs > math.MaxUint32 || numWords > math.MaxUint32 || numNonZeros > uint64(len(data)) ||
+		docIdBytes > uint64(len(data)) || vocabBytes > uint64(len(data)) {
+		return errors.New("corrupted header")
+	}
+	size := uint64(binaryCorpusHeaderSize) + 8*(2*numDocs+2+numWords+1) +
+		4*(2*numNonZeros+numDocs+numWords) + docIdBytes + vocabBytes
+	if size != uint64(len(data)) {
+		return errors.New(fmt.Sprintf("expected %d bytes but the file has %d", size, len(data)))
+	}
+	c.numDocs, c.numWords = int(numDocs), int(numWords)
+	offset := uint64(binaryCorpusHeaderSize)
+	section := func(n uint64) []byte {
+		s := data[offset : offset+n]
+		offset += n
+		return s
+	}
+	c.docStart = section(8 * (numDocs + 1))
+	c.docIdStart = section(8 * (numDocs + 1))
+	c.wordStart = section(8 * (numWords + 1))
+	c.wordIds = section(4 * numNonZeros)
+	c.counts = section(4 * numNonZeros)
+	c.docIdOrder = section(4 * numDocs)
+	c.wordOrder = section(4 * numWords)
+	c.docIdData = section(docIdBytes)
+	c.vocabData = section(vocabBytes)
+	return nil
+}
+
+// Verify checks the offsets, word indices and sort orders of the loaded
+// file, reading every page of it.
+func (c *MappedCorpus) Verify() error {
+	if c.data == nil {
+		return errors.New("MappedCorpus.Verify() failed: no corpus is loaded")
+	}
+	if err := c.verify(); err != nil {
+		return errors.New(fmt.Sprintf("MappedCorpus.Verify() failed: %s", err))
+	}
+	return nil
+}
+
+func (c *MappedCorpus) verify() error {
+	numNonZeros, docIdBytes, vocabBytes := uint64(len(c.wordIds)/4), uint64(len(c.docIdData)), uint64(len(c.vocabData))
+	if !c.increasing(c.docStart, c.numDocs, numNonZeros) ||
+		!c.increasing(c.docIdStart, c.numDocs, docIdBytes) ||
+		!c.increasing(c.wordStart, c.numWords, vocabBytes) {
+		return errors.New("corrupted offsets")
+	}
+	if !c.validWordIds() {
+		return errors.New("corrupted word indices")
+	}
+	if !c.sortedOrder(c.docIdOrder, c.numDocs, c.docIdBytes) || !c.sortedOrder(c.wordOrder, c.numWords, c.wordBytes) {
+		return errors.New("corrupted sort order")
+	}
+	return nil
+}
+
+// validWordIds checks that the word indices of each document are in the
+// vocabulary and strictly increasing, which the binary search of
+// DocWordCount relies on.
+func (c *MappedCorpus) validWordIds() bool {
+	for d := 0; d < c.numDocs; d++ {
+		begin, end := int(c.uint64At(c.docStart, d)), int(c.uint64At(c.docStart, d+1))
+		for i := begin; i < end; i++ {
+			w := c.uint32At(c.wordIds, i)
+			if int64(w) >= int64(c.numWords) || (i > begin && w <= c.uint32At(c.wordIds, i-1)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sortedOrder checks that order is a permutation of the n indices sorting
+// the strings given by str.
+func (c *MappedCorpus) sortedOrder(order []byte, n int, str func(int) []byte) bool {
+	seen := make([]bool, n)
+	for i := 0; i < n; i++ {
+		j := c.uint32At(order, i)
+		if int64(j) >= int64(n) || seen[j] {
+			return false
+		}
+		seen[j] = true
+		if i > 0 && bytes.Compare(str(int(c.uint32At(order, i-1))), str(int(j))) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// increasing checks that the n+1 offsets of the given section go from 0 to
+// last without decreasing.
+func (c *MappedCorpus) increasing(section []byte, n int, last uint64) bool {
+	prev := uint64(0)
+	for i := 0; i <= n; i++ {
+		offset := c.uint64At(section, i)
+		if offset < prev {
+			return false
+		}
+		prev = offset
+	}
+	return c.uint64At(section, 0) == 0 && prev == last
+}
+
+func (c *MappedCorpus) uint64At(section []byte, i int) uint64 {
+	return binary.LittleEndian.Uint64(section[8*i:])
+}
+
+func (c *MappedCorpus) uint32At(section []byte, i int) uint32 {
+	return binary.LittleEndian.Uint32(section[4*i:])
+}
+
+// docIdBytes returns the document id of index d in the mapped data.
+func (c *MappedCorpus) docIdBytes(d int) []byte {
+	return c.docIdData[c.uint64At(c.docIdStart, d):c.uint64At(c.docIdStart, d+1)]
+}
+
+// wordBytes returns the word of index w in the mapped data.
+func (c *MappedCorpus) wordBytes(w int) []byte {
+	return c.vocabData[c.uint64At(c.wordStart, w):c.uint64At(c.wordStart, w+1)]
+}
+
+// find returns the index of s among the n strings whose sorted order is
+// given by order, or -1 if it is not found. The strings are compared in
+// the mapped data without copying them.
+func (c *MappedCorpus) find(s string, n int, order []byte, str func(int) []byte) int {
+	key := []byte(s)
+	i := sort.Search(n, func(i int) bool { return bytes.Compare(str(int(c.uint32At(order, i))), key) >= 0 })
+	if i < n {
+		if j := int(c.uint32At(order, i)); bytes.Equal(str(j), key) {
+			return j
+		}
+	}
+	return -1
+}
+
+func (c *MappedCorpus) CorpusIds() []string {
+	c.docIdsOnce.Do(func() {
+		c.docIds = make([]string, c.numDocs)
+		for d := range c.docIds {
+			c.docIds[d] = string(c.docIdBytes(d))
+		}
+	})
+	return c.docIds
+}
+
+func (c *MappedCorpus) CorpusSize() int {
+	return c.numDocs
+}
+
+func (c *MappedCorpus) Vocabulary() []string {
+	c.vocabOnce.Do(func() {
+		c.vocab = make([]string, c.numWords)
+		for w := range c.vocab {
+			c.vocab[w] = string(c.wordBytes(w))
+		}
+	})
+	return c.vocab
+}
+
+func (c *MappedCorpus) VocabularySize() int {
+	return c.numWords
+}
+
+func (c *MappedCorpus) DocWordCount(docId, word string) uint64 {
+	d := c.find(docId, c.numDocs, c.docIdOrder, c.docIdBytes)
+	if d < 0 {
+		return 0
+	}
+	w := c.find(word, c.numWords, c.wordOrder, c.wordBytes)
+	if w < 0 {
+		return 0
+	}
+	begin, end := int(c.uint64At(c.docStart, d)), int(c.uint64At(c.docStart, d+1))
+	i := begin + sort.Search(end-begin, func(i int) bool { return c.uint32At(c.wordIds, begin+i) >= uint32(w) })
+	if i < end && c.uint32At(c.wordIds, i) == uint32(w) {
+		return uint64(c.uint32At(c.counts, i))
+	}
+	return 0
+}
+
+// ForEachWordInDoc calls processor with each word of the given document
+// in the order of the vocabulary.
+func (c *MappedCorpus) ForEachWordInDoc(docId string, processor func(word string, count uint64)) {
+	d := c.find(docId, c.numDocs, c.docIdOrder, c.docIdBytes)
+	if d < 0 {
+		return
+	}
+	vocab := c.Vocabulary()
+	for i, end := int(c.uint64At(c.docStart, d)), int(c.uint64At(c.docStart, d+1)); i < end; i++ {
+		processor(vocab[c.uint32At(c.wordIds, i)], uint64(c.uint32At(c.counts, i)))
+	}
+}
@@ -0,0 +1,336 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// trainingState holds everything an EM run needs to continue, which is
+// what a checkpoint saves.
+type trainingState struct {
+	model          *Model
+	rng            *countingSource // source of the random initialization
+	iteration      int             // number of EM iterations done
+	likelihoods    []float64       // training log likelihood of each iteration
+	prevLikelihood float64         // likelihood the next improvement is measured against, NaN if none
+	beta           float64         // inverse temperature of the E-step
+	best           *Model          // parameters with the best held-out perplexity, nil if none
+	bestPerplexity float64
+	improvedAtBeta bool // whether the held-out perplexity improved at the current beta
+
+	sinceImprovement int // iterations since the held-out perplexity last improved
+}
+
+// newTrainingState initializes the model parameters with a random source
+// seeded with the given seed.
+func newTrainingState(corpus *sparseCorpus, param *TrainingParameter, seed int64) *trainingState {
+	s := &trainingState{
+		model:          &Model{},
+		rng:            newCountingSource(seed, 0),
+		prevLikelihood: math.NaN(),
+		beta:           param.Beta,
+		bestPerplexity: math.Inf(1),
+	}
+	s.model.initialize(corpus, param, rand.New(s.rng))
+	(*s.model).param.Seed = seed
+	if s.beta <= 0 {
+		s.beta = 1
+	}
+	return s
+}
+
+// countingSource is a rand.Source counting the values drawn from it, so
+// that its state can be saved as the seed and the number of draws.
+type countingSource struct {
+	source rand.Source64
+	seed   int64
+	draws  uint64
+}
+
+// newCountingSource returns the source seeded with seed after the given
+// number of draws.
+func newCountingSource(seed int64, draws uint64) *countingSource {
+	s := &countingSource{source: rand.NewSource(seed).(rand.Source64), seed: seed}
+	for s.draws < draws {
+		s.Int63()
+	}
+	return s
+}
+
+func (s *countingSource) Int63() int64 {
+	s.draws++
+	return s.source.Int63()
+}
+
+func (s *countingSource) Uint64() uint64 {
+	s.draws++
+	return s.source.Uint64()
+}
+
+func (s *countingSource) Seed(seed int64) {
+	s.source.Seed(seed)
+	s.seed, s.draws = seed, 0
+}
+
+// checkpointDue tells whether a checkpoint should be written before the
+// next iteration.
+func (s *trainingState) checkpointDue(param *TrainingParameter, lastCheckpoint time.Time) bool {
+	if param.CheckpointFile == "" || s.iteration == 0 {
+		return false
+	}
+	return (param.CheckpointEvery > 0 && s.iteration%param.CheckpointEvery == 0) ||
+		(param.CheckpointInterval > 0 && time.Since(lastCheckpoint) >= param.CheckpointInterval)
+}
+
+// The checkpoint format follows the model format:
+//
+//	plsa-checkpoint <version>
+//	iteration <number of iterations done>
+//	rng <seed> <number of draws>
+//	beta <beta>
+//	prev_likelihood <likelihood>
+//	held_out <true|false>
+//	best_perplexity <perplexity>
+//	improved_at_beta <true|false>
+//	since_improvement <iterations since the held-out perplexity improved>
+//	likelihoods <number of iterations done>
+//	l <likelihood of each iteration>
+//	<model>
+//	best <0|1>
+//	<model with the best held-out perplexity, if best is 1>
+const (
+	checkpointFormatName    = "plsa-checkpoint"
+	checkpointFormatVersion = 2
+)
+
+// saveCheckpoint atomically replaces filename with the training state:
+// the state is written to a temporary file in the same directory, which is
+// synced and renamed to filename, so that a crash leaves either the
+// previous checkpoint or the new one.
+func (s *trainingState) saveCheckpoint(filename string, heldOut bool) error {
+	err := writeFileAtomic(filename, func(w *bufio.Writer) error {
+		fmt.Fprintf(w, "%s %d\n", checkpointFormatName, checkpointFormatVersion)
+		fmt.Fprintf(w, "iteration %d\n", s.iteration)
+		fmt.Fprintf(w, "rng %d %d\n", s.rng.seed, s.rng.draws)
+		fmt.Fprintf(w, "beta %s\n", formatProb(s.beta))
+		fmt.Fprintf(w, "prev_likelihood %s\n", formatProb(s.prevLikelihood))
+		fmt.Fprintf(w, "held_out %t\n", heldOut)
+		fmt.Fprintf(w, "best_perplexity %s\n", formatProb(s.bestPerplexity))
+		fmt.Fprintf(w, "improved_at_beta %t\n", s.improvedAtBeta)
+		fmt.Fprintf(w, "since_improvement %d\n", s.sinceImprovement)
+		fmt.Fprintf(w, "likelihoods %d\n", len(s.likelihoods))
+		w.WriteString("l")
+		for _, l := range s.likelihoods {
+			w.WriteString(" " + formatProb(l))
+		}
+		w.WriteString("\n")
+		if err := s.model.write(w); err != nil {
+			return err
+		}
+		if s.best == nil {
+			_, err := fmt.Fprintf(w, "best 0\n")
+			return err
+		}
+		fmt.Fprintf(w, "best 1\n")
+		return s.best.write(w)
+	})
+	if err != nil {
+		return errors.New(fmt.Sprintf("saveCheckpoint(%s) failed: %s", filename, err))
+	}
+	log.Printf("Saved checkpoint of iteration %d to %s.\n", s.iteration, filename)
+	return nil
+}
+
+// writeFileAtomic writes filename through a temporary file that is renamed
+// to filename once its content is safely on disk.
+func writeFileAtomic(filename string, write func(w *bufio.Writer) error) error {
+	fd, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fd)
+	if err = write(w); err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = fd.Sync()
+	}
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(fd.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(fd.Name())
+	}
+	return err
+}
+
+// readCheckpoint reads a checkpoint written by saveCheckpoint and tells
+// whether the run used a held-out corpus.
+func readCheckpoint(r *modelReader) (s *trainingState, heldOut bool, err error) {
+	header, err := r.fields(checkpointFormatName, 2)
+	if err != nil {
+		return nil, false, errors.New("not a PLSA checkpoint file: " + err.Error())
+	}
+	if version, err := strconv.Atoi(header[1]); err != nil || version != checkpointFormatVersion {
+		return nil, false, r.errorf("unsupported checkpoint format version [%s], expected %d", header[1], checkpointFormatVersion)
+	}
+	s = &trainingState{}
+	if s.iteration, err = r.count("iteration"); err != nil {
+		return nil, false, err
+	}
+	f, err := r.fields("rng", 3)
+	if err != nil {
+		return nil, false, err
+	}
+	seed, err := strconv.ParseInt(f[1], 10, 64)
+	if err != nil {
+		return nil, false, r.errorf("invalid seed [%s]", f[1])
+	}
+	draws, err := strconv.ParseUint(f[2], 10, 64)
+	if err != nil {
+		return nil, false, r.errorf("invalid number of draws [%s]", f[2])
+	}
+	s.rng = newCountingSource(seed, draws)
+	if s.beta, err = r.float("beta"); err != nil {
+		return nil, false, err
+	}
+	if s.prevLikelihood, err = r.float("prev_likelihood"); err != nil {
+		return nil, false, err
+	}
+	if heldOut, err = r.bool("held_out"); err != nil {
+		return nil, false, err
+	}
+	if s.bestPerplexity, err = r.float("best_perplexity"); err != nil {
+		return nil, false, err
+	}
+	if s.improvedAtBeta, err = r.bool("improved_at_beta"); err != nil {
+		return nil, false, err
+	}
+	if s.sinceImprovement, err = r.count("since_improvement"); err != nil {
+		return nil, false, err
+	}
+	n, err := r.count("likelihoods")
+	if err != nil {
+		return nil, false, err
+	}
+	if s.likelihoods, err = r.probs("l", n); err != nil {
+		return nil, false, err
+	}
+	if s.model, err = readModel(r); err != nil {
+		return nil, false, err
+	}
+	hasBest, err := r.count("best")
+	if err != nil {
+		return nil, false, err
+	}
+	if hasBest == 1 {
+		if s.best, err = readModel(r); err != nil {
+			return nil, false, err
+		}
+	}
+	return s, heldOut, nil
+}
+
+// float reads a keyword line holding a single floating point value.
+func (r *modelReader) float(keyword string) (float64, error) {
+	f, err := r.fields(keyword, 2)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseFloat(f[1], 64)
+	if err != nil {
+		return 0, r.errorf("invalid %s [%s]", keyword, f[1])
+	}
+	return v, nil
+}
+
+// bool reads a keyword line holding a single boolean value.
+func (r *modelReader) bool(keyword string) (bool, error) {
+	f, err := r.fields(keyword, 2)
+	if err != nil {
+		return false, err
+	}
+	v, err := strconv.ParseBool(f[1])
+	if err != nil {
+		return false, r.errorf("invalid %s [%s]", keyword, f[1])
+	}
+	return v, nil
+}
+
+// ResumeTraining continues the training run that wrote the given
+// checkpoint, on the same docWordFreq, as if it had never been
+// interrupted. The training parameter is the one saved in the checkpoint,
+// with the default stopping criterion, and further checkpoints are written
+// to checkpointPath.
+func ResumeTraining(checkpointPath string, docWordFreq DocWordFreqRetriever) (*Model, error) {
+	return ResumeTrainingWithHeldOut(checkpointPath, docWordFreq, nil)
+}
+
+// ResumeTrainingWithHeldOut is ResumeTraining for runs using the held-out
+// corpus heldOut, which must be the one of the interrupted run.
+func ResumeTrainingWithHeldOut(checkpointPath string, docWordFreq, heldOut DocWordFreqRetriever) (*Model, error) {
+	s, err := loadCheckpoint(checkpointPath, docWordFreq, heldOut)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("ResumeTraining(%s) failed: %s", checkpointPath, err))
+	}
+	param := s.model.param
+	param.HeldOut = heldOut
+	param.CheckpointFile = checkpointPath
+	corpus := newSparseCorpus(docWordFreq, s.model.wordIndex)
+	var heldOutCorpus *sparseCorpus
+	if heldOut != nil {
+		heldOutCorpus = newSparseCorpus(heldOut, s.model.wordIndex)
+	}
+	log.Printf("EM training resumed at iteration %d: %v, seed %d.\n", s.iteration, param, param.Seed)
+	return s.run(context.Background(), corpus, heldOutCorpus, &param, nil)
+}
+
+// loadCheckpoint reads the checkpoint and checks that it matches the
+// given corpora.
+func loadCheckpoint(filename string, docWordFreq, heldOut DocWordFreqRetriever) (*trainingState, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	s, usedHeldOut, err := readCheckpoint(&modelReader{reader: bufio.NewReader(file)})
+	if err != nil {
+		return nil, err
+	}
+	if usedHeldOut != (heldOut != nil) {
+		return nil, errors.New(fmt.Sprintf("the run used a held-out corpus: %t, but one is given: %t", usedHeldOut, heldOut != nil))
+	}
+	if !sameStrings(docWordFreq.Vocabulary(), s.model.vocab) || !sameStrings(docWordFreq.CorpusIds(), s.model.docIds) {
+		return nil, errors.New("the corpus differs from the one of the checkpoint")
+	}
+	return s, nil
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -6,8 +6,10 @@ package plsa
 
 import (
 	"bufio"
+	"charset"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
@@ -16,76 +18,199 @@ import (
 
 type LineFieldExtractor func(string) (docId, word string, count uint64, err error)
 
+// SimpleLineFieldExtractor returns a LineFieldExtractor for lines of the
+// form <docId><docWordSep><word><wordCountSep><count>.
 func SimpleLineFieldExtractor(docWordSep, wordCountSep string) LineFieldExtractor {
 	return func(line string) (docId, word string, count uint64, err error) {
-		tokens := strings.SplitN(line, docWordSep, 1)
+		tokens := strings.SplitN(line, docWordSep, 2)
 		if len(tokens) != 2 {
 			err = errors.New(fmt.Sprintf("Cannot split [%s] to two fields using docWordSep[%s]", line, docWordSep))
 			return
 		}
 		docId = tokens[0]
-		n_tokens := strings.SplitN(tokens[1], wordCountSep, 1)
+		n_tokens := strings.SplitN(tokens[1], wordCountSep, 2)
 		if len(n_tokens) != 2 {
 			err = errors.New(fmt.Sprintf("Cannot split [%s] to two fields using wordCountSep[%s]", tokens[1], wordCountSep))
 			return
 		}
 		word = n_tokens[0]
-		count, err = strconv.ParseUint(n_tokens[1], 10, 64)
+		count, err = strconv.ParseUint(strings.TrimSpace(n_tokens[1]), 10, 64)
 		return
 	}
 }
 
+// LineError describes a line of the input file that could not be loaded.
+type LineError struct {
+	LineNo int    // Line number, starting from 1.
+	Line   string // Content of the line.
+	Err    error  // Reason why the line was rejected.
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d [%s]: %s", e.LineNo, e.Line, e.Err)
+}
+
+// MaxReportedLineErrors is the maximum number of rejected lines kept in
+// a LoadReport and logged.
+const MaxReportedLineErrors = 100
+
+// LoadReport summarizes the result of LineOrientedLoader.LoadFromFile.
+type LoadReport struct {
+	Lines      int          // Number of lines read, including blank lines.
+	Loaded     int          // Number of lines loaded.
+	Skipped    int          // Number of lines rejected.
+	Duplicates int          // Number of lines redefining an already loaded document-word count.
+	Errors     []*LineError // The first MaxReportedLineErrors rejected lines.
+}
+
+func (r *LoadReport) String() string {
+	return fmt.Sprintf("%d lines read, %d loaded, %d skipped, %d duplicates",
+		r.Lines, r.Loaded, r.Skipped, r.Duplicates)
+}
+
+type docWordIndex struct {
+	doc, word int32
+}
+
+// LineOrientedLoader loads document-word counts from a text file having
+// one document-word count per line, as parsed by its LineFieldExtractor.
+// Blank lines are ignored. The file is read as a stream rather than as a
+// whole, but the counts are kept in a map keyed by document-word pair,
+// which takes several times the memory of the counts themselves.
+//
+// In strict mode, loading fails on the first line that cannot be parsed
+// or that redefines the count of a document-word pair. Otherwise such
+// lines are skipped (a redefinition replaces the previous count) and
+// summarized in the LoadReport; only the first MaxReportedLineErrors
+// skipped lines are logged.
+//
+// The file is transcoded from Encoding to UTF-8 while it is read.
 type LineOrientedLoader struct {
+	Strict    bool
+	Encoding  charset.Encoding
 	vocab     []string
 	docIds    []string
-	count     map[docIdWord]uint64
+	wordIndex map[string]int32
+	docIndex  map[string]int32
+	docWords  [][]int32 // indices of the words of each document
+	count     map[docWordIndex]uint64
 	extractor LineFieldExtractor
+	report    LoadReport
 }
 
 func NewLineOrientedLoader(extactor_func LineFieldExtractor) *LineOrientedLoader {
 	var loader LineOrientedLoader
 	loader.extractor = extactor_func
+	loader.reset()
 	return &loader
 }
 
-func (loader *LineOrientedLoader) LoadFromFile(docWordFreqFile string) bool {
+func (loader *LineOrientedLoader) reset() {
+	(*loader).vocab = nil
+	(*loader).docIds = nil
+	(*loader).wordIndex = make(map[string]int32)
+	(*loader).docIndex = make(map[string]int32)
+	(*loader).docWords = nil
+	(*loader).count = make(map[docWordIndex]uint64)
+	(*loader).report = LoadReport{}
+}
+
+// LoadFromFile loads the document-word counts from the given file,
+// replacing any previously loaded counts.
+func (loader *LineOrientedLoader) LoadFromFile(docWordFreqFile string) error {
 	fd, err := os.Open(docWordFreqFile)
 	if err != nil {
-		log.Printf("LineOrientedLoader.LoadFromFile(%s) failed: %s", docWordFreqFile, err)
-		return false
+		return err
+	}
+	defer fd.Close()
+	loader.reset()
+	err = loader.load(bufio.NewReader(charset.NewReader(fd, loader.Encoding)))
+	if err != nil {
+		return errors.New(fmt.Sprintf("LineOrientedLoader.LoadFromFile(%s) failed: %s", docWordFreqFile, err))
 	}
+	if loader.report.Skipped > 0 || loader.report.Duplicates > 0 {
+		log.Printf("LineOrientedLoader.LoadFromFile(%s): %s", docWordFreqFile, &loader.report)
+	}
+	return nil
+}
 
-	reader := bufio.NewReader(fd)
-	vocabMap := make(map[string]bool)
-	docIdMap := make(map[string]bool)
+func (loader *LineOrientedLoader) load(reader *bufio.Reader) error {
+	report := &(*loader).report
 	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			break
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
 		}
-		docId, word, count, err := loader.extractor(line)
-		if err != nil {
-			log.Printf("Failed to extract fields from line [%s]: %s", line, err)
-			continue
+		if readErr == io.EOF && len(line) == 0 {
+			return nil
 		}
-
-		if !docIdMap[docId] {
-			(*loader).docIds = append((*loader).docIds, docId)
+		report.Lines++
+		line = strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(line) != "" {
+			if err := loader.add(line); err != nil {
+				lineErr := &LineError{report.Lines, line, err}
+				if loader.Strict {
+					return lineErr
+				}
+				if report.Skipped < MaxReportedLineErrors {
+					log.Printf("Skipped %s", lineErr)
+				} else if report.Skipped == MaxReportedLineErrors {
+					log.Printf("Skipped more than %d lines, further skipped lines are not logged.", MaxReportedLineErrors)
+				}
+				report.Skipped++
+				if len(report.Errors) < MaxReportedLineErrors {
+					report.Errors = append(report.Errors, lineErr)
+				}
+			} else {
+				report.Loaded++
+			}
 		}
-
-		if !vocabMap[word] {
-			(*loader).vocab = append((*loader).vocab, word)
+		if readErr == io.EOF {
+			return nil
 		}
+	}
+}
+
+func (loader *LineOrientedLoader) add(line string) error {
+	docId, word, count, err := loader.extractor(line)
+	if err != nil {
+		return err
+	}
+	if docId == "" || word == "" {
+		return errors.New("empty document id or word")
+	}
+
+	d, found := loader.docIndex[docId]
+	if !found {
+		d = int32(len(loader.docIds))
+		(*loader).docIndex[docId] = d
+		(*loader).docIds = append((*loader).docIds, docId)
+		(*loader).docWords = append((*loader).docWords, nil)
+	}
+	w, found := loader.wordIndex[word]
+	if !found {
+		w = int32(len(loader.vocab))
+		(*loader).wordIndex[word] = w
+		(*loader).vocab = append((*loader).vocab, word)
+	}
 
-		docIdWordVal := docIdWord{docId, word}
-		if countVal, found := (*loader).count[docIdWordVal]; found == true {
-			log.Printf("Error, found duplicated definition of %v, old value is %v, new value is %v",
-				docIdWordVal, countVal, count)
+	key := docWordIndex{d, w}
+	if countVal, found := loader.count[key]; found {
+		if loader.Strict {
+			return errors.New(fmt.Sprintf("duplicated definition of (%s, %s), old value is %v, new value is %v",
+				docId, word, countVal, count))
 		}
-		(*loader).count[docIdWordVal] = count
+		(*loader).report.Duplicates++
+	} else {
+		(*loader).docWords[d] = append((*loader).docWords[d], w)
 	}
+	(*loader).count[key] = count
+	return nil
+}
 
-	return true
+// Report returns the summary of the last call to LoadFromFile.
+func (loader *LineOrientedLoader) Report() *LoadReport {
+	return &(*loader).report
 }
 
 func (loader *LineOrientedLoader) CorpusIds() []string {
@@ -105,5 +230,25 @@ func (loader *LineOrientedLoader) VocabularySize() int {
 }
 
 func (loader *LineOrientedLoader) DocWordCount(docId, word string) uint64 {
-	return (*loader).count[docIdWord{docId, word}]
+	d, found := loader.docIndex[docId]
+	if !found {
+		return 0
+	}
+	w, found := loader.wordIndex[word]
+	if !found {
+		return 0
+	}
+	return (*loader).count[docWordIndex{d, w}]
+}
+
+// ForEachWordInDoc calls processor with each word of the given document
+// in the order they appear in the file.
+func (loader *LineOrientedLoader) ForEachWordInDoc(docId string, processor func(word string, count uint64)) {
+	d, found := loader.docIndex[docId]
+	if !found {
+		return
+	}
+	for _, w := range loader.docWords[d] {
+		processor(loader.vocab[w], loader.count[docWordIndex{d, w}])
+	}
 }
@@ -0,0 +1,226 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"charset"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLineOrientedLoader(t *testing.T) {
+	testFile := "doc_word_count_test.txt"
+	defer func() {
+		os.Remove(testFile)
+	}()
+	content := "d1 鲜花 3\n" +
+		"d1 玫瑰 2\r\n" +
+		"\n" +
+		"d2 鲜花 x\n" +
+		"d2 游戏 4\n" +
+		"d1 鲜花 5\n" +
+		"d2 动画 1"
+	if err := ioutil.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file [%s]: %s", testFile, err)
+	}
+
+	loader := NewLineOrientedLoader(SimpleLineFieldExtractor(" ", " "))
+	if err := loader.LoadFromFile(testFile); err != nil {
+		t.Fatalf("LineOrientedLoader.LoadFromFile(%s) failed: %s", testFile, err)
+	}
+	if loader.CorpusSize() != 2 || loader.VocabularySize() != 4 {
+		t.Errorf("Expected 2 documents and 4 words but got %v and %v.", loader.CorpusIds(), loader.Vocabulary())
+	}
+	expected := map[docIdWord]uint64{
+		{"d1", "鲜花"}: 5, {"d1", "玫瑰"}: 2, {"d1", "游戏"}: 0,
+		{"d2", "游戏"}: 4, {"d2", "动画"}: 1, {"d3", "动画"}: 0,
+	}
+	for k, n := range expected {
+		if c := loader.DocWordCount(k.docId, k.word); c != n {
+			t.Errorf("Expected count of %v to be %d but got %d.", k, n, c)
+		}
+	}
+	words := 0
+	loader.ForEachWordInDoc("d2", func(word string, count uint64) {
+		words++
+		if count != expected[docIdWord{"d2", word}] {
+			t.Errorf("ForEachWordInDoc: unexpected count %d for word %s.", count, word)
+		}
+	})
+	if words != 2 {
+		t.Errorf("Expected 2 words in d2 but got %d.", words)
+	}
+	report := loader.Report()
+	if report.Lines != 7 || report.Loaded != 5 || report.Skipped != 1 || report.Duplicates != 1 {
+		t.Errorf("Unexpected load report: %s.", report)
+	}
+	if len(report.Errors) != 1 || report.Errors[0].LineNo != 4 {
+		t.Errorf("Expected line 4 to be reported as rejected but got %v.", report.Errors)
+	}
+
+	strict := NewLineOrientedLoader(SimpleLineFieldExtractor(" ", " "))
+	strict.Strict = true
+	if err := strict.LoadFromFile(testFile); err == nil {
+		t.Errorf("Expected LoadFromFile to fail in strict mode.")
+	}
+}
+
+func TestBinaryCorpus(t *testing.T) {
+	testFile := "binary_corpus_test.bin"
+	defer func() {
+		os.Remove(testFile)
+	}()
+	corpus := twoTopicCorpus()
+	if err := WriteBinaryCorpus(corpus, testFile); err != nil {
+		t.Fatalf("WriteBinaryCorpus failed: %s", err)
+	}
+	mapped := NewMappedCorpus()
+	if err := mapped.LoadFromFile(testFile); err != nil {
+		t.Fatalf("MappedCorpus.LoadFromFile(%s) failed: %s", testFile, err)
+	}
+	defer mapped.Close()
+	if !reflect.DeepEqual(mapped.CorpusIds(), corpus.CorpusIds()) || !reflect.DeepEqual(mapped.Vocabulary(), corpus.Vocabulary()) {
+		t.Errorf("Expected %v and %v but got %v and %v.", corpus.CorpusIds(), corpus.Vocabulary(),
+			mapped.CorpusIds(), mapped.Vocabulary())
+	}
+	for _, d := range append(corpus.CorpusIds(), "d9") {
+		for _, w := range append(corpus.Vocabulary(), "未知") {
+			if c := mapped.DocWordCount(d, w); c != corpus.DocWordCount(d, w) {
+				t.Errorf("Expected count of (%s, %s) to be %d but got %d.", d, w, corpus.DocWordCount(d, w), c)
+			}
+		}
+	}
+	words := 0
+	mapped.ForEachWordInDoc("d4", func(word string, count uint64) {
+		words++
+		if count != corpus.DocWordCount("d4", word) {
+			t.Errorf("ForEachWordInDoc: unexpected count %d for word %s.", count, word)
+		}
+	})
+	if words != 3 {
+		t.Errorf("Expected 3 words in d4 but got %d.", words)
+	}
+
+	content, err := ioutil.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %s", testFile, err)
+	}
+	numDocs, numWords, numNonZeros := len(corpus.CorpusIds()), len(corpus.Vocabulary()), 16
+	wordIds := binaryCorpusHeaderSize + 8*(2*numDocs+2+numWords+1)
+	docIdOrder := wordIds + 8*numNonZeros
+	corrupted := make(map[string][]byte)
+	for name, offset := range map[string]int{"word index": wordIds, "document order": docIdOrder} {
+		c := append([]byte(nil), content...)
+		c[offset], c[offset+1] = 0xff, 0xff
+		corrupted[name] = c
+	}
+	if err := mapped.Verify(); err != nil {
+		t.Errorf("MappedCorpus.Verify failed on a valid file: %s", err)
+	}
+	for name, c := range corrupted {
+		if err := ioutil.WriteFile(testFile, c, 0644); err != nil {
+			t.Fatalf("Failed to create test file [%s]: %s", testFile, err)
+		}
+		if err := mapped.LoadFromFile(testFile); err == nil {
+			err = mapped.Verify()
+			if err == nil {
+				t.Errorf("Expected a file with corrupted %s to be rejected.", name)
+			}
+		}
+	}
+	if err := ioutil.WriteFile(testFile, []byte("d1 鲜花 3\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test file [%s]: %s", testFile, err)
+	}
+	if err := mapped.LoadFromFile(testFile); err == nil || mapped.CorpusSize() != 0 {
+		t.Errorf("Expected a file that is not a binary corpus to be rejected.")
+	}
+}
+
+// sameCorpus reports whether two corpora have the same documents, words
+// and counts.
+func sameCorpus(a, b DocWordFreqRetriever) bool {
+	if !reflect.DeepEqual(a.CorpusIds(), b.CorpusIds()) || !reflect.DeepEqual(a.Vocabulary(), b.Vocabulary()) {
+		return false
+	}
+	for _, d := range a.CorpusIds() {
+		for _, w := range a.Vocabulary() {
+			if a.DocWordCount(d, w) != b.DocWordCount(d, w) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestExchangeFormats(t *testing.T) {
+	dataFile, vocabFile, docIdFile := "exchange_test.txt", "exchange_test.vocab", "exchange_test.docs"
+	defer func() {
+		os.Remove(dataFile)
+		os.Remove(vocabFile)
+		os.Remove(docIdFile)
+	}()
+	corpus := twoTopicCorpus()
+
+	if err := WriteUCICorpus(corpus, dataFile, vocabFile, docIdFile, charset.UTF8); err != nil {
+		t.Fatalf("WriteUCICorpus failed: %s", err)
+	}
+	uci := NewUCICorpus(vocabFile)
+	uci.DocIdFile = docIdFile
+	if err := uci.LoadFromFile(dataFile); err != nil {
+		t.Fatalf("UCICorpus.LoadFromFile failed: %s", err)
+	}
+	if !sameCorpus(corpus, uci) {
+		t.Errorf("UCI round trip changed the corpus.")
+	}
+	uci.DocIdFile = ""
+	if err := uci.LoadFromFile(dataFile); err != nil || uci.CorpusIds()[5] != "6" || uci.DocWordCount("4", "游戏") != 4 {
+		t.Errorf("Expected documents to be numbered from 1 without a document id file.")
+	}
+
+	if err := WriteLDACCorpus(corpus, dataFile, vocabFile, docIdFile, charset.UTF8); err != nil {
+		t.Fatalf("WriteLDACCorpus failed: %s", err)
+	}
+	ldac := NewLDACCorpus(vocabFile)
+	ldac.DocIdFile = docIdFile
+	if err := ldac.LoadFromFile(dataFile); err != nil {
+		t.Fatalf("LDACCorpus.LoadFromFile failed: %s", err)
+	}
+	if !sameCorpus(corpus, ldac) {
+		t.Errorf("LDA-C round trip changed the corpus.")
+	}
+
+	ioutil.WriteFile(dataFile, []byte("2 0:1 7:2\n"), 0644)
+	ldac.DocIdFile = ""
+	if err := ldac.LoadFromFile(dataFile); err == nil {
+		t.Errorf("Expected out of range word number to be rejected.")
+	}
+
+	if err := WriteUCICorpus(corpus, dataFile, vocabFile, docIdFile, charset.GB18030); err != nil {
+		t.Fatalf("WriteUCICorpus failed: %s", err)
+	}
+	uci = NewUCICorpus(vocabFile)
+	uci.DocIdFile = docIdFile
+	uci.Encoding = charset.GB18030
+	if err := uci.LoadFromFile(dataFile); err != nil || !sameCorpus(corpus, uci) {
+		t.Errorf("GB18030 UCI round trip changed the corpus: %v.", err)
+	}
+	uci.DocIdFile = ""
+	for name, docWords := range map[string]string{
+		"huge header":     "18446744073709551615\n7\n1\n1 1 1\n",
+		"too many counts": "1\n7\n1\n1 1 1\n1 2 1\n",
+	} {
+		ioutil.WriteFile(dataFile, []byte(docWords), 0644)
+		if err := uci.LoadFromFile(dataFile); err == nil {
+			t.Errorf("Expected UCI file with %s to be rejected.", name)
+		}
+	}
+	ioutil.WriteFile(dataFile, []byte("1\n2\n1\n1 1 1\n"), 0644)
+	ioutil.WriteFile(vocabFile, []byte("鲜花\n鲜花 \n"), 0644)
+	if err := uci.LoadFromFile(dataFile); err == nil {
+		t.Errorf("Expected duplicated vocabulary words to be rejected.")
+	}
+}
@@ -0,0 +1,358 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// emStats holds the sufficient statistics accumulated in the E-step,
+// i.e. the expected counts n(w,z), n(d,z) and n(z) under the posterior
+// P(z|d,w). The posterior itself is never stored, it is computed for one
+// non-zero document-word count at a time.
+//
+// The documents are split into shards which are processed by separate
+// goroutines. Each shard accumulates its own n(w,z) and n(z), which are
+// then summed in shard order so that the result does not depend on the
+// scheduling of the goroutines. Shards cover disjoint sets of documents,
+// hence they share n(d,z).
+type emStats struct {
+	wordTopic [][]float64 // n(w,z), indexed by [z][w]
+	docTopic  [][]float64 // n(d,z), indexed by [z][d]
+	topic     []float64   // n(z)
+	beta      float64     // inverse temperature of the E-step, 1 for plain EM
+	change    []float64   // largest change of a probability of each topic in the M-step
+	shards    []*emShard
+
+	// Logarithms of P(z), P(d|z) and P(w|z), only used by the log-space
+	// E-step, nil otherwise.
+	logTopic     []float64
+	logDocTopic  [][]float64
+	logWordTopic [][]float64
+}
+
+// emShard holds the partial statistics of a contiguous range of documents.
+type emShard struct {
+	begin, end int         // range of documents [begin, end)
+	wordTopic  [][]float64 // partial n(w,z), aliases emStats.wordTopic for the first shard
+	topic      []float64   // partial n(z), aliases emStats.topic for the first shard
+	posterior  []float64   // scratch space for P(z|d,w)
+	likelihood float64
+}
+
+func newEMStats(numTopics int, corpus *sparseCorpus, workers int, logSpace bool) *emStats {
+	var s emStats
+	s.wordTopic = newMatrix(numTopics, len(corpus.vocab))
+	s.docTopic = newMatrix(numTopics, corpus.numDocs())
+	s.topic = make([]float64, numTopics)
+	s.beta = 1
+	s.change = make([]float64, numTopics)
+	if logSpace {
+		s.logTopic = make([]float64, numTopics)
+		s.logDocTopic = newMatrix(numTopics, corpus.numDocs())
+		s.logWordTopic = newMatrix(numTopics, len(corpus.vocab))
+	}
+	for i, r := range splitDocuments(corpus, workers) {
+		shard := &emShard{begin: r[0], end: r[1], posterior: make([]float64, numTopics)}
+		if i == 0 {
+			shard.wordTopic, shard.topic = s.wordTopic, s.topic
+		} else {
+			shard.wordTopic = newMatrix(numTopics, len(corpus.vocab))
+			shard.topic = make([]float64, numTopics)
+		}
+		s.shards = append(s.shards, shard)
+	}
+	return &s
+}
+
+func newMatrix(rows, cols int) [][]float64 {
+	m := make([][]float64, rows)
+	for i := range m {
+		m[i] = make([]float64, cols)
+	}
+	return m
+}
+
+// splitDocuments splits the documents of the corpus into at most n
+// contiguous ranges having roughly the same number of non-zero counts.
+func splitDocuments(corpus *sparseCorpus, n int) [][2]int {
+	if n < 1 {
+		n = 1
+	}
+	numDocs := corpus.numDocs()
+	var ranges [][2]int
+	begin := 0
+	for i := 1; i <= n && begin < numDocs; i++ {
+		end := begin + 1
+		target := corpus.numNonZeros() * i / n
+		for end < numDocs && corpus.docStart[end] < target {
+			end++
+		}
+		if i == n {
+			end = numDocs
+		}
+		ranges = append(ranges, [2]int{begin, end})
+		begin = end
+	}
+	if len(ranges) == 0 {
+		ranges = append(ranges, [2]int{0, numDocs})
+	}
+	return ranges
+}
+
+func zero(a []float64) {
+	for i := range a {
+		a[i] = 0
+	}
+}
+
+// parallelFor calls f(i) for i in [0, n) using at most workers goroutines,
+// and returns when all calls are done.
+func parallelFor(n, workers int, f func(i int)) {
+	if workers <= 1 || n <= 1 {
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	next := make(chan int, n)
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	for g := 0; g < workers && g < n; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				f(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// emIteration performs one EM iteration over the corpus and returns the
+// log likelihood of the corpus under the model parameters prior to the
+// update.
+func (m *Model) emIteration(corpus *sparseCorpus, stats *emStats) float64 {
+	return m.emIterationWith(corpus, stats, func(z int) {
+		m.mStep(corpus, stats, z)
+	})
+}
+
+// emIterationWith is emIteration using mStep to re-estimate the parameters
+// of each topic from the expected counts.
+func (m *Model) emIterationWith(corpus *sparseCorpus, stats *emStats, mStep func(z int)) float64 {
+	workers := len(stats.shards)
+	if stats.logTopic != nil {
+		parallelFor(m.NumberOfTopics(), workers, func(z int) {
+			stats.logTopic[z] = math.Log(m.topicProb[z])
+			logOf(stats.logDocTopic[z], m.docTopicProb[z])
+			logOf(stats.logWordTopic[z], m.wordTopicProb[z])
+		})
+	}
+	parallelFor(workers, workers, func(i int) {
+		m.eStep(corpus, stats, stats.shards[i])
+	})
+	likelihood := float64(0)
+	for _, shard := range stats.shards {
+		likelihood += shard.likelihood
+	}
+	parallelFor(m.NumberOfTopics(), workers, func(z int) {
+		stats.reduce(z)
+		mStep(z)
+	})
+	return likelihood
+}
+
+// eStep computes P(z|d,w) for every non-zero document-word count of the
+// documents in the shard and accumulates the expected counts. If the
+// inverse temperature beta is not 1, the tempered posterior proportional
+// to [P(z)P(d|z)P(w|z)]^beta is used instead.
+func (m *Model) eStep(corpus *sparseCorpus, stats *emStats, shard *emShard) {
+	numTopics := m.NumberOfTopics()
+	for z := 0; z < numTopics; z++ {
+		zero(shard.wordTopic[z])
+		zero(stats.docTopic[z][shard.begin:shard.end])
+	}
+	zero(shard.topic)
+
+	p := shard.posterior
+	likelihood := float64(0)
+	for d := shard.begin; d < shard.end; d++ {
+		for i := corpus.docStart[d]; i < corpus.docStart[d+1]; i++ {
+			w := corpus.wordIds[i]
+			var log_p_d_w float64
+			if stats.logTopic != nil {
+				log_p_d_w = m.logPosterior(stats, p, d, w)
+			} else {
+				log_p_d_w = m.posterior(stats, p, d, w)
+			}
+			if math.IsInf(log_p_d_w, -1) {
+				continue
+			}
+			count := corpus.counts[i]
+			likelihood += count * log_p_d_w
+			for z := 0; z < numTopics; z++ {
+				n := count * p[z]
+				shard.wordTopic[z][w] += n
+				stats.docTopic[z][d] += n
+				shard.topic[z] += n
+			}
+		}
+	}
+	shard.likelihood = likelihood
+}
+
+// posterior stores the normalized (tempered) posterior P(z|d,w) in p and
+// returns log P(d,w), or -Inf if P(d,w) is 0, in which case p is undefined.
+func (m *Model) posterior(stats *emStats, p []float64, d int, w int32) float64 {
+	norm_constant := float64(0)
+	for z := range p {
+		p[z] = m.topicProb[z] * m.docTopicProb[z][d] * m.wordTopicProb[z][w]
+		norm_constant += p[z]
+	}
+	if norm_constant <= 0 {
+		return math.Inf(-1)
+	}
+	log_p_d_w := math.Log(norm_constant)
+	if stats.beta != 1 {
+		norm_constant = 0
+		for z := range p {
+			p[z] = math.Pow(p[z], stats.beta)
+			norm_constant += p[z]
+		}
+		if norm_constant <= 0 {
+			return math.Inf(-1)
+		}
+	}
+	for z := range p {
+		p[z] /= norm_constant
+	}
+	return log_p_d_w
+}
+
+// logPosterior is posterior computed from the logarithms of the model
+// parameters with the log-sum-exp trick, so that products of small
+// probabilities, and their powers in tempered EM, do not underflow.
+func (m *Model) logPosterior(stats *emStats, p []float64, d int, w int32) float64 {
+	max := math.Inf(-1)
+	for z := range p {
+		p[z] = stats.logTopic[z] + stats.logDocTopic[z][d] + stats.logWordTopic[z][w]
+		max = math.Max(max, p[z])
+	}
+	if math.IsInf(max, -1) {
+		return max
+	}
+	total := float64(0)
+	for z := range p {
+		total += math.Exp(p[z] - max)
+	}
+	log_p_d_w := max + math.Log(total)
+
+	beta := stats.beta
+	total = 0
+	for z := range p {
+		p[z] = math.Exp(beta * (p[z] - max))
+		total += p[z]
+	}
+	for z := range p {
+		p[z] /= total
+	}
+	return log_p_d_w
+}
+
+// logOf stores the natural logarithm of each element of a in dst.
+func logOf(dst, a []float64) {
+	for i, v := range a {
+		dst[i] = math.Log(v)
+	}
+}
+
+// reduce sums the partial statistics of topic z of all shards into the
+// first one.
+func (s *emStats) reduce(z int) {
+	for _, shard := range s.shards[1:] {
+		for w, n := range shard.wordTopic[z] {
+			s.wordTopic[z][w] += n
+		}
+		s.topic[z] += shard.topic[z]
+	}
+}
+
+// mStep re-estimates P(z), P(d|z) and P(w|z) of topic z from the expected
+// counts, recording the largest change of these probabilities.
+func (m *Model) mStep(corpus *sparseCorpus, stats *emStats, z int) {
+	n_z := stats.topic[z]
+	change := math.Abs(n_z/corpus.total - m.topicProb[z])
+	(*m).topicProb[z] = n_z / corpus.total
+	if n_z > 0 {
+		for w, n_w_z := range stats.wordTopic[z] {
+			change = math.Max(change, math.Abs(n_w_z/n_z-m.wordTopicProb[z][w]))
+			(*m).wordTopicProb[z][w] = n_w_z / n_z
+		}
+		for d, n_d_z := range stats.docTopic[z] {
+			change = math.Max(change, math.Abs(n_d_z/n_z-m.docTopicProb[z][d]))
+			(*m).docTopicProb[z][d] = n_d_z / n_z
+		}
+	}
+	stats.change[z] = change
+}
+
+// parameterChange returns the largest change of a probability of the
+// model in the last M-step.
+func (s *emStats) parameterChange() float64 {
+	change := float64(0)
+	for _, c := range s.change {
+		change = math.Max(change, c)
+	}
+	return change
+}
+
+// ProbabilityTolerance is the largest deviation from 1 of the sum of a
+// distribution, and the largest relative decrease of the likelihood
+// between two EM iterations, accepted by the invariant checks of
+// TrainingParameter.Debug.
+const ProbabilityTolerance = 1e-6
+
+// checkInvariants returns an error if P(z), P(w|z) or P(d|z) of a topic
+// with non-zero probability does not sum to 1 within ProbabilityTolerance,
+// or contains a negative or NaN probability.
+func (m *Model) checkInvariants() error {
+	if err := checkDistribution("P(z)", m.topicProb); err != nil {
+		return err
+	}
+	for z, pz := range m.topicProb {
+		if pz == 0 {
+			continue
+		}
+		if err := checkDistribution(fmt.Sprintf("P(w|z=%d)", z), m.wordTopicProb[z]); err != nil {
+			return err
+		}
+		if err := checkDistribution(fmt.Sprintf("P(d|z=%d)", z), m.docTopicProb[z]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkDistribution(name string, p []float64) error {
+	total := float64(0)
+	for i, v := range p {
+		if v < 0 || math.IsNaN(v) {
+			return errors.New(fmt.Sprintf("%s has invalid probability %g at %d", name, v, i))
+		}
+		total += v
+	}
+	if math.Abs(total-1) > ProbabilityTolerance {
+		return errors.New(fmt.Sprintf("%s sums to %.12f", name, total))
+	}
+	return nil
+}
@@ -0,0 +1,522 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"bufio"
+	"charset"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// This file reads and writes corpora in the formats used by other topic
+// model toolkits:
+//
+// The UCI bag-of-words format, as in docword.*.txt, starts with three lines
+// holding the number of documents, the number of words and the number of
+// non-zero counts, followed by one "docID wordID count" line per non-zero
+// count, where documents and words are numbered from 1. The words are
+// listed in a separate vocabulary file, as in vocab.*.txt, one per line.
+//
+// The LDA-C format of David Blei has one line per document of the form
+// "M term_1:count_1 ... term_M:count_M", where M is the number of distinct
+// words of the document and words are numbered from 0 in the vocabulary
+// file.
+//
+// Neither format names the documents, so they are identified by their
+// number, starting from 1, unless a document id file holding one id per
+// line is given.
+
+// indexedCorpus holds document-word counts in memory, the words of each
+// document being sorted by index.
+type indexedCorpus struct {
+	docIds    []string
+	vocab     []string
+	docIndex  map[string]int
+	wordIndex map[string]int
+	docWords  [][]int32
+	docCounts [][]uint64
+}
+
+func (c *indexedCorpus) reset(vocab []string) {
+	(*c).docIds = nil
+	(*c).vocab = vocab
+	(*c).docIndex = make(map[string]int)
+	(*c).wordIndex = make(map[string]int, len(vocab))
+	for i, w := range vocab {
+		(*c).wordIndex[w] = i
+	}
+	(*c).docWords = nil
+	(*c).docCounts = nil
+}
+
+// addDoc appends a document with the given words and counts, which are
+// sorted in place.
+func (c *indexedCorpus) addDoc(docId string, words []int32, counts []uint64) error {
+	if _, found := c.docIndex[docId]; found {
+		return errors.New(fmt.Sprintf("duplicated document id [%s]", docId))
+	}
+	sort.Sort(&wordCounts{words, counts})
+	for i := 1; i < len(words); i++ {
+		if words[i] == words[i-1] {
+			return errors.New(fmt.Sprintf("duplicated count of word %d in document [%s]", words[i], docId))
+		}
+	}
+	(*c).docIndex[docId] = len(c.docIds)
+	(*c).docIds = append((*c).docIds, docId)
+	(*c).docWords = append((*c).docWords, words)
+	(*c).docCounts = append((*c).docCounts, counts)
+	return nil
+}
+
+// setDocIds renames the documents, which are numbered from 1 by default.
+func (c *indexedCorpus) setDocIds(docIds []string) error {
+	if len(docIds) != len(c.docIds) {
+		return errors.New(fmt.Sprintf("%d document ids for %d documents", len(docIds), len(c.docIds)))
+	}
+	(*c).docIndex = make(map[string]int, len(docIds))
+	for i, d := range docIds {
+		if _, found := c.docIndex[d]; found {
+			return errors.New(fmt.Sprintf("duplicated document id [%s]", d))
+		}
+		(*c).docIndex[d] = i
+	}
+	(*c).docIds = docIds
+	return nil
+}
+
+type wordCounts struct {
+	words  []int32
+	counts []uint64
+}
+
+func (s *wordCounts) Len() int           { return len(s.words) }
+func (s *wordCounts) Less(i, j int) bool { return s.words[i] < s.words[j] }
+func (s *wordCounts) Swap(i, j int) {
+	s.words[i], s.words[j] = s.words[j], s.words[i]
+	s.counts[i], s.counts[j] = s.counts[j], s.counts[i]
+}
+
+func (c *indexedCorpus) CorpusIds() []string {
+	return (*c).docIds
+}
+
+func (c *indexedCorpus) CorpusSize() int {
+	return len((*c).docIds)
+}
+
+func (c *indexedCorpus) Vocabulary() []string {
+	return (*c).vocab
+}
+
+func (c *indexedCorpus) VocabularySize() int {
+	return len((*c).vocab)
+}
+
+func (c *indexedCorpus) DocWordCount(docId, word string) uint64 {
+	d, found := c.docIndex[docId]
+	if !found {
+		return 0
+	}
+	w, found := c.wordIndex[word]
+	if !found {
+		return 0
+	}
+	words := c.docWords[d]
+	i := sort.Search(len(words), func(i int) bool { return words[i] >= int32(w) })
+	if i < len(words) && words[i] == int32(w) {
+		return c.docCounts[d][i]
+	}
+	return 0
+}
+
+// ForEachWordInDoc calls processor with each word of the given document
+// in the order of the vocabulary.
+func (c *indexedCorpus) ForEachWordInDoc(docId string, processor func(word string, count uint64)) {
+	d, found := c.docIndex[docId]
+	if !found {
+		return
+	}
+	for i, w := range c.docWords[d] {
+		processor(c.vocab[w], c.docCounts[d][i])
+	}
+}
+
+// readLines returns the lines of the given file, without the trailing
+// empty line.
+func readLines(filename string, enc charset.Encoding) ([]string, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	var lines []string
+	reader := bufio.NewReader(charset.NewReader(fd, enc))
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			lines = append(lines, strings.TrimRight(line, "\r\n"))
+		}
+		if err == io.EOF {
+			return lines, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// forEachDataLine calls processor with each non-blank line of the given
+// file and its line number, starting from 1.
+func forEachDataLine(filename string, processor func(lineNo int, fields []string) error) error {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	reader := bufio.NewReader(fd)
+	for lineNo := 1; ; lineNo++ {
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			if err := processor(lineNo, fields); err != nil {
+				return errors.New(fmt.Sprintf("line %d [%s]: %s", lineNo, strings.TrimSpace(line), err))
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+	}
+}
+
+// sizeOf returns the size of the given file in bytes.
+func sizeOf(filename string) (uint64, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(info.Size()), nil
+}
+
+func parseUints(fields []string) ([]uint64, error) {
+	values := make([]uint64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// loadExchangeFormat loads the vocabulary, calls load to read the counts
+// and finally renames the documents if a document id file is given.
+func (c *indexedCorpus) loadExchangeFormat(vocabFile, docIdFile string, enc charset.Encoding, load func() error) error {
+	vocab, err := readLines(vocabFile, enc)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(vocab))
+	for i := range vocab {
+		vocab[i] = strings.TrimSpace(vocab[i])
+		if seen[vocab[i]] {
+			return errors.New(fmt.Sprintf("duplicated word [%s] in %s", vocab[i], vocabFile))
+		}
+		seen[vocab[i]] = true
+	}
+	c.reset(vocab)
+	if err := load(); err != nil {
+		return err
+	}
+	if docIdFile != "" {
+		docIds, err := readLines(docIdFile, enc)
+		if err != nil {
+			return err
+		}
+		return c.setDocIds(docIds)
+	}
+	return nil
+}
+
+// UCICorpus is a DocWordFreqRetriever reading the UCI bag-of-words format.
+// LoadFromFile reads the docword file, VocabFile the vocabulary and, if not
+// empty, DocIdFile the document ids. The vocabulary and document ids are
+// transcoded from Encoding. The header may declare at most as many
+// documents as the docword file has bytes.
+type UCICorpus struct {
+	VocabFile string
+	DocIdFile string
+	Encoding  charset.Encoding
+	indexedCorpus
+}
+
+func NewUCICorpus(vocabFile string) *UCICorpus {
+	return &UCICorpus{VocabFile: vocabFile}
+}
+
+func (c *UCICorpus) LoadFromFile(docWordFile string) error {
+	err := c.loadExchangeFormat(c.VocabFile, c.DocIdFile, c.Encoding, func() error {
+		return c.load(docWordFile)
+	})
+	if err != nil {
+		return errors.New(fmt.Sprintf("UCICorpus.LoadFromFile(%s) failed: %s", docWordFile, err))
+	}
+	return nil
+}
+
+// load reads the docword file. The counts are collected by document
+// number as they are read rather than allocated from the header, and the
+// number of documents is bounded by the size of the file, so that a
+// corrupted header cannot make the loader allocate without bound.
+func (c *UCICorpus) load(docWordFile string) error {
+	fileSize, err := sizeOf(docWordFile)
+	if err != nil {
+		return err
+	}
+	var header []uint64
+	words := make(map[uint64][]int32)
+	counts := make(map[uint64][]uint64)
+	nonZeros := uint64(0)
+	err = forEachDataLine(docWordFile, func(lineNo int, fields []string) error {
+		if len(header) < 3 {
+			if len(fields) != 1 {
+				return errors.New("expected a single number in the header")
+			}
+			v, err := strconv.ParseUint(fields[0], 10, 64)
+			if err != nil {
+				return err
+			}
+			header = append(header, v)
+			if len(header) == 3 {
+				if header[1] != uint64(len(c.vocab)) {
+					return errors.New(fmt.Sprintf("%d words declared but the vocabulary has %d", header[1], len(c.vocab)))
+				}
+				if header[0] > fileSize {
+					return errors.New(fmt.Sprintf("%d documents declared in a file of %d bytes", header[0], fileSize))
+				}
+			}
+			return nil
+		}
+		if len(fields) != 3 {
+			return errors.New("expected \"docID wordID count\"")
+		}
+		v, err := parseUints(fields)
+		if err != nil {
+			return err
+		}
+		d, w, count := v[0], v[1], v[2]
+		if d < 1 || d > header[0] || w < 1 || w > header[1] {
+			return errors.New("document or word number out of range")
+		}
+		nonZeros++
+		if count > 0 {
+			words[d] = append(words[d], int32(w-1))
+			counts[d] = append(counts[d], count)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(header) < 3 {
+		return errors.New("incomplete header")
+	}
+	if nonZeros != header[2] {
+		return errors.New(fmt.Sprintf("%d counts declared but %d found", header[2], nonZeros))
+	}
+	for d := uint64(1); d <= header[0]; d++ {
+		if err := c.addDoc(strconv.FormatUint(d, 10), words[d], counts[d]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LDACCorpus is a DocWordFreqRetriever reading the LDA-C format.
+// LoadFromFile reads the data file, VocabFile the vocabulary and, if not
+// empty, DocIdFile the document ids. The vocabulary and document ids are
+// transcoded from Encoding.
+type LDACCorpus struct {
+	VocabFile string
+	DocIdFile string
+	Encoding  charset.Encoding
+	indexedCorpus
+}
+
+func NewLDACCorpus(vocabFile string) *LDACCorpus {
+	return &LDACCorpus{VocabFile: vocabFile}
+}
+
+func (c *LDACCorpus) LoadFromFile(dataFile string) error {
+	err := c.loadExchangeFormat(c.VocabFile, c.DocIdFile, c.Encoding, func() error {
+		return c.load(dataFile)
+	})
+	if err != nil {
+		return errors.New(fmt.Sprintf("LDACCorpus.LoadFromFile(%s) failed: %s", dataFile, err))
+	}
+	return nil
+}
+
+func (c *LDACCorpus) load(dataFile string) error {
+	return forEachDataLine(dataFile, func(lineNo int, fields []string) error {
+		m, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return err
+		}
+		if m != len(fields)-1 {
+			return errors.New(fmt.Sprintf("%d words declared but %d found", m, len(fields)-1))
+		}
+		words := make([]int32, 0, m)
+		counts := make([]uint64, 0, m)
+		for _, f := range fields[1:] {
+			tokens := strings.SplitN(f, ":", 2)
+			if len(tokens) != 2 {
+				return errors.New(fmt.Sprintf("expected term:count but got [%s]", f))
+			}
+			v, err := parseUints(tokens)
+			if err != nil {
+				return err
+			}
+			if v[0] >= uint64(len(c.vocab)) {
+				return errors.New(fmt.Sprintf("word number %d out of range", v[0]))
+			}
+			if v[1] > 0 {
+				words = append(words, int32(v[0]))
+				counts = append(counts, v[1])
+			}
+		}
+		return c.addDoc(strconv.Itoa(len(c.docIds)+1), words, counts)
+	})
+}
+
+// exchangeWriter writes a corpus in one of the exchange formats.
+type exchangeWriter struct {
+	docWordFreq DocWordFreqRetriever
+	wordIndex   map[string]int
+}
+
+func newExchangeWriter(docWordFreq DocWordFreqRetriever) *exchangeWriter {
+	w := &exchangeWriter{docWordFreq, make(map[string]int)}
+	for i, word := range docWordFreq.Vocabulary() {
+		w.wordIndex[word] = i
+	}
+	return w
+}
+
+// docWords returns the indices and counts of the words of the given
+// document, sorted by index.
+func (w *exchangeWriter) docWords(docId string) ([]int32, []uint64) {
+	var words []int32
+	var counts []uint64
+	forEachWordInDoc(w.docWordFreq, docId, func(word string, count uint64) {
+		if i, found := w.wordIndex[word]; found && count > 0 {
+			words = append(words, int32(i))
+			counts = append(counts, count)
+		}
+	})
+	sort.Sort(&wordCounts{words, counts})
+	return words, counts
+}
+
+// writeFile writes a file using writer, reporting the first error.
+func writeFile(filename string, write func(w *bufio.Writer) error) error {
+	fd, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fd)
+	if err = write(w); err == nil {
+		err = w.Flush()
+	}
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writeLines writes the lines to the given file, encoded in enc.
+func writeLines(filename string, lines []string, enc charset.Encoding) error {
+	return writeFile(filename, func(w *bufio.Writer) error {
+		out := charset.NewWriter(w, enc)
+		for _, line := range lines {
+			if strings.ContainsAny(line, "\r\n") {
+				return errors.New(fmt.Sprintf("[%s] contains a new line", line))
+			}
+			if _, err := fmt.Fprintln(out, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WriteUCICorpus writes the document-word counts of docWordFreq in the UCI
+// bag-of-words format, with the vocabulary in vocabFile and, unless
+// docIdFile is empty, the document ids in docIdFile. The vocabulary and
+// document ids are encoded in enc.
+func WriteUCICorpus(docWordFreq DocWordFreqRetriever, docWordFile, vocabFile, docIdFile string, enc charset.Encoding) error {
+	w := newExchangeWriter(docWordFreq)
+	err := writeFile(docWordFile, func(out *bufio.Writer) error {
+		docIds := docWordFreq.CorpusIds()
+		words := make([][]int32, len(docIds))
+		counts := make([][]uint64, len(docIds))
+		nonZeros := 0
+		for d, docId := range docIds {
+			words[d], counts[d] = w.docWords(docId)
+			nonZeros += len(words[d])
+		}
+		fmt.Fprintf(out, "%d\n%d\n%d\n", len(docIds), docWordFreq.VocabularySize(), nonZeros)
+		for d := range docIds {
+			for i, word := range words[d] {
+				if _, err := fmt.Fprintf(out, "%d %d %d\n", d+1, word+1, counts[d][i]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = writeLabels(docIdFile, vocabFile, docWordFreq.CorpusIds(), docWordFreq.Vocabulary(), enc)
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("WriteUCICorpus(%s) failed: %s", docWordFile, err))
+	}
+	return nil
+}
+
+// WriteLDACCorpus writes the document-word counts of docWordFreq in the
+// LDA-C format, with the vocabulary in vocabFile and, unless docIdFile is
+// empty, the document ids in docIdFile. The vocabulary and document ids are
+// encoded in enc.
+func WriteLDACCorpus(docWordFreq DocWordFreqRetriever, dataFile, vocabFile, docIdFile string, enc charset.Encoding) error {
+	w := newExchangeWriter(docWordFreq)
+	err := writeFile(dataFile, func(out *bufio.Writer) error {
+		for _, docId := range docWordFreq.CorpusIds() {
+			words, counts := w.docWords(docId)
+			fmt.Fprintf(out, "%d", len(words))
+			for i, word := range words {
+				fmt.Fprintf(out, " %d:%d", word, counts[i])
+			}
+			if _, err := fmt.Fprintln(out); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = writeLabels(docIdFile, vocabFile, docWordFreq.CorpusIds(), docWordFreq.Vocabulary(), enc)
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("WriteLDACCorpus(%s) failed: %s", dataFile, err))
+	}
+	return nil
+}
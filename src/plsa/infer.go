@@ -0,0 +1,115 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"math"
+	"sort"
+)
+
+// InferenceParameter holds the parameter for inferring the topic mixture
+// of a document that is not part of the training corpus. Fields left at 0
+// take the value of DefaultInferenceParameter.
+type InferenceParameter struct {
+	MaxIteration       int     // Maximum number of steps in the fold-in EM procedure.
+	LikelihoodIncLimit float64 // Minimum relative likelihood increment reached before stopping.
+}
+
+// DefaultInferenceParameter is used by Infer when no InferenceParameter
+// is given.
+var DefaultInferenceParameter = InferenceParameter{
+	MaxIteration:       100,
+	LikelihoodIncLimit: 0.0001,
+}
+
+// withDefaults returns opts with the fields left at 0 set to those of
+// DefaultInferenceParameter.
+func (opts *InferenceParameter) withDefaults() *InferenceParameter {
+	p := DefaultInferenceParameter
+	if opts != nil && opts.MaxIteration > 0 {
+		p.MaxIteration = opts.MaxIteration
+	}
+	if opts != nil && opts.LikelihoodIncLimit > 0 {
+		p.LikelihoodIncLimit = opts.LikelihoodIncLimit
+	}
+	return &p
+}
+
+// Infer computes the topic mixture P(z|d) of a new document given its
+// word counts, using the fold-in procedure: EM is run on the new document
+// with P(w|z) of the model held fixed. Words that are not part of the
+// model are ignored; if none of the words is known to the model, P(z)
+// is returned. If opts is nil, DefaultInferenceParameter is used.
+func (model *Model) Infer(wordCounts map[string]uint64, opts *InferenceParameter) []float64 {
+	var words []int32
+	for word, count := range wordCounts {
+		if w, found := model.wordIndex[word]; found && count > 0 {
+			words = append(words, int32(w))
+		}
+	}
+	// Sort the words so that the result does not depend on map ordering.
+	sort.Sort(int32Slice(words))
+	counts := make([]float64, len(words))
+	for i, w := range words {
+		counts[i] = float64(wordCounts[model.vocab[w]])
+	}
+	topicMixture := make([]float64, model.NumberOfTopics())
+	model.foldIn(words, counts, topicMixture, opts)
+	return topicMixture
+}
+
+type int32Slice []int32
+
+func (s int32Slice) Len() int           { return len(s) }
+func (s int32Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s int32Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// foldIn estimates P(z|d) of a document consisting of the given word
+// indices and counts, storing the result in topicMixture, and returns
+// the log likelihood sum_w n(d,w) log sum_z P(z|d)P(w|z) of the document.
+func (m *Model) foldIn(words []int32, counts []float64, topicMixture []float64,
+	opts *InferenceParameter) float64 {
+	opts = opts.withDefaults()
+	numTopics := m.NumberOfTopics()
+	copy(topicMixture, m.topicProb)
+	if len(words) == 0 {
+		return 0
+	}
+	p := make([]float64, numTopics)
+	next := make([]float64, numTopics)
+	prev_likelihood := float64(0)
+	likelihood := float64(0)
+	for iter := 0; iter < opts.MaxIteration; iter++ {
+		zero(next)
+		likelihood = 0
+		total := float64(0)
+		for i, w := range words {
+			norm_constant := float64(0)
+			for z := 0; z < numTopics; z++ {
+				p[z] = topicMixture[z] * m.wordTopicProb[z][w]
+				norm_constant += p[z]
+			}
+			if norm_constant <= 0 {
+				continue
+			}
+			likelihood += counts[i] * math.Log(norm_constant)
+			for z := 0; z < numTopics; z++ {
+				next[z] += counts[i] * p[z] / norm_constant
+			}
+			total += counts[i]
+		}
+		if total <= 0 {
+			break
+		}
+		for z := range topicMixture {
+			topicMixture[z] = next[z] / total
+		}
+		if iter > 0 && math.Abs((likelihood-prev_likelihood)/prev_likelihood) < opts.LikelihoodIncLimit {
+			break
+		}
+		prev_likelihood = likelihood
+	}
+	return likelihood
+}
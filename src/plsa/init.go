@@ -0,0 +1,451 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"errors"
+	"fmt"
+	"kmean"
+	"log"
+	"math"
+	"math/rand"
+)
+
+// Initializer selects how the model parameters are initialized before the
+// first EM iteration.
+//
+// Except for RandomInit, the initializers first choose P(w|z) for each
+// topic, mixed with weight InitSmoothing with the unigram distribution of
+// the corpus so that no word starts with a zero probability, then derive
+// P(z) and P(d|z) from the expected counts of one E-step under uniform
+// P(z) and P(d|z).
+type Initializer int
+
+const (
+	// RandomInit draws P(d|z) and P(w|z) uniformly at random.
+	RandomInit Initializer = iota
+	// KMeansInit clusters the TF-IDF vectors of the documents with spherical
+	// k-means, and starts each topic from the word distribution of a
+	// cluster. At most InitSampleSize documents, drawn at random, are
+	// clustered.
+	KMeansInit
+	// RandomDocInit starts each topic from the word distribution of a
+	// document drawn at random.
+	RandomDocInit
+	// NMFInit factorizes the TF-IDF document-word matrix into non-negative
+	// document-topic and topic-word matrices, and starts each topic from a
+	// row of the latter.
+	NMFInit
+)
+
+// InitSmoothing is the weight of the corpus unigram distribution in the
+// initial P(w|z) of the initializers other than RandomInit.
+const InitSmoothing = 0.1
+
+// DefaultInitSampleSize is the number of documents clustered by KMeansInit
+// when TrainingParameter.InitSampleSize is 0.
+const DefaultInitSampleSize = 1000
+
+// nmfIterations is the number of multiplicative updates done by NMFInit.
+const nmfIterations = 50
+
+var initializerNames = []string{"random", "kmeans", "random_doc", "nmf"}
+
+func (i Initializer) String() string {
+	if i >= 0 && int(i) < len(initializerNames) {
+		return initializerNames[i]
+	}
+	return fmt.Sprintf("Initializer(%d)", int(i))
+}
+
+// ParseInitializer returns the Initializer of the given name, as returned
+// by its String method.
+func ParseInitializer(name string) (Initializer, error) {
+	for i, n := range initializerNames {
+		if n == name {
+			return Initializer(i), nil
+		}
+	}
+	return RandomInit, errors.New(fmt.Sprintf("unknown initializer [%s]", name))
+}
+
+// initialize sets up the model for training on the given corpus and
+// initializes its parameters with param.Init, drawing random numbers from r.
+func (m *Model) initialize(corpus *sparseCorpus, param *TrainingParameter, r *rand.Rand) {
+	m.randomInit(corpus, param, r)
+	if param.Init == RandomInit || corpus.numDocs() == 0 {
+		return
+	}
+	numTopics := m.NumberOfTopics()
+	var topics [][]float64 // unnormalized P(w|z)
+	switch param.Init {
+	case KMeansInit:
+		sampleSize := param.InitSampleSize
+		if sampleSize <= 0 {
+			sampleSize = DefaultInitSampleSize
+		}
+		topics = kmeansTopics(corpus, numTopics, sampleSize, r)
+	case RandomDocInit:
+		topics = randomDocTopics(corpus, numTopics, r)
+	case NMFInit:
+		topics = nmfTopics(corpus, numTopics, r)
+	default:
+		log.Printf("Unknown initializer %s, using random initialization.\n", param.Init)
+		return
+	}
+	m.initFromTopics(corpus, topics)
+}
+
+// initFromTopics sets P(w|z) to the given unnormalized word weights of each
+// topic smoothed with the corpus unigram distribution, then sets P(z) and
+// P(d|z) from the expected counts n(z) and n(d,z) under uniform P(z) and
+// P(d|z).
+func (m *Model) initFromTopics(corpus *sparseCorpus, topics [][]float64) {
+	numTopics := len(topics)
+	unigram := make([]float64, len(corpus.vocab))
+	for i, w := range corpus.wordIds {
+		unigram[w] += corpus.counts[i]
+	}
+	normalizeOrUniform(unigram)
+	for z, weights := range topics {
+		normalizeOrUniform(weights)
+		for w, p := range weights {
+			(*m).wordTopicProb[z][w] = (1-InitSmoothing)*p + InitSmoothing*unigram[w]
+		}
+	}
+
+	docTopic := make([][]float64, numTopics)
+	for z := range docTopic {
+		docTopic[z] = make([]float64, corpus.numDocs())
+	}
+	posterior := make([]float64, numTopics)
+	for d := 0; d < corpus.numDocs(); d++ {
+		for i := corpus.docStart[d]; i < corpus.docStart[d+1]; i++ {
+			w := corpus.wordIds[i]
+			total := float64(0)
+			for z := range posterior {
+				posterior[z] = m.wordTopicProb[z][w]
+				total += posterior[z]
+			}
+			if total == 0 {
+				continue
+			}
+			for z, p := range posterior {
+				docTopic[z][d] += corpus.counts[i] * p / total
+			}
+		}
+	}
+	topicMass := make([]float64, numTopics)
+	for z := range docTopic {
+		for _, n := range docTopic[z] {
+			topicMass[z] += n
+		}
+		normalizeOrUniform(docTopic[z])
+		copy((*m).docTopicProb[z], docTopic[z])
+	}
+	normalizeOrUniform(topicMass)
+	copy((*m).topicProb, topicMass)
+}
+
+// normalizeOrUniform scales v to sum to 1, or makes it uniform if it sums to 0.
+func normalizeOrUniform(v []float64) {
+	total := float64(0)
+	for _, x := range v {
+		total += x
+	}
+	for i := range v {
+		if total > 0 {
+			v[i] /= total
+		} else {
+			v[i] = 1 / float64(len(v))
+		}
+	}
+}
+
+// docWordCounts returns the counts of the words of document d as a dense
+// vector over the vocabulary, added to v if it is not nil.
+func docWordCounts(corpus *sparseCorpus, d int, v []float64) []float64 {
+	if v == nil {
+		v = make([]float64, len(corpus.vocab))
+	}
+	for i := corpus.docStart[d]; i < corpus.docStart[d+1]; i++ {
+		v[corpus.wordIds[i]] += corpus.counts[i]
+	}
+	return v
+}
+
+// randomDocTopics returns the word counts of numTopics documents drawn at
+// random, distinct unless there are fewer documents than topics.
+func randomDocTopics(corpus *sparseCorpus, numTopics int, r *rand.Rand) [][]float64 {
+	perm := r.Perm(corpus.numDocs())
+	topics := make([][]float64, numTopics)
+	for z := range topics {
+		d := r.Intn(corpus.numDocs())
+		if z < len(perm) {
+			d = perm[z]
+		}
+		topics[z] = docWordCounts(corpus, d, nil)
+	}
+	return topics
+}
+
+// tfidfWeights returns the inverse document frequency log(N/df) of each
+// word.
+func tfidfWeights(corpus *sparseCorpus) []float64 {
+	docFreq := make([]float64, len(corpus.vocab))
+	for _, w := range corpus.wordIds {
+		docFreq[w]++
+	}
+	idf := make([]float64, len(docFreq))
+	for w, df := range docFreq {
+		if df > 0 {
+			idf[w] = math.Log(float64(corpus.numDocs()) / df)
+		}
+	}
+	return idf
+}
+
+// tfidfVector returns the TF-IDF weights of the words of document d, with
+// term frequencies relative to the length of the document.
+func tfidfVector(corpus *sparseCorpus, d int, idf []float64) map[int32]float64 {
+	begin, end := corpus.docStart[d], corpus.docStart[d+1]
+	length := float64(0)
+	for i := begin; i < end; i++ {
+		length += corpus.counts[i]
+	}
+	v := make(map[int32]float64, end-begin)
+	for i := begin; i < end; i++ {
+		if weight := corpus.counts[i] / length * idf[corpus.wordIds[i]]; weight > 0 {
+			v[corpus.wordIds[i]] = weight
+		}
+	}
+	return v
+}
+
+// kmeansTopics clusters the TF-IDF vectors of at most sampleSize documents
+// drawn at random into numTopics clusters, and returns the summed word
+// counts of the members of each cluster. Topics whose cluster is empty
+// start from a random document.
+func kmeansTopics(corpus *sparseCorpus, numTopics, sampleSize int, r *rand.Rand) [][]float64 {
+	idf := tfidfWeights(corpus)
+	var samples tfidfSamples
+	for _, d := range r.Perm(corpus.numDocs()) {
+		if len(samples) == sampleSize {
+			break
+		}
+		if v := tfidfVector(corpus, d, idf); len(v) > 0 {
+			samples = append(samples, tfidfSample{d, v, 0})
+		}
+	}
+	if len(samples) < numTopics {
+		log.Printf("Only %d documents to cluster into %d topics, initializing from random documents.\n",
+			len(samples), numTopics)
+		return randomDocTopics(corpus, numTopics, r)
+	}
+	clusters := kmean.SphericalKMeanClusterWithRand(samples, numTopics, r)
+	topics := make([][]float64, numTopics)
+	for z := range topics {
+		if z < len(clusters) && len(clusters[z].Members) > 0 {
+			for _, s := range clusters[z].Members {
+				topics[z] = docWordCounts(corpus, s.Id(), topics[z])
+			}
+		} else {
+			topics[z] = docWordCounts(corpus, r.Intn(corpus.numDocs()), nil)
+		}
+	}
+	return topics
+}
+
+// nmfTopics factorizes the TF-IDF document-word matrix X into W H, where W
+// is the document-topic and H the topic-word matrix, using the
+// multiplicative updates of Lee and Seung minimizing ||X - W H||^2, and
+// returns H.
+func nmfTopics(corpus *sparseCorpus, numTopics int, r *rand.Rand) [][]float64 {
+	idf := tfidfWeights(corpus)
+	numDocs, numWords := corpus.numDocs(), len(corpus.vocab)
+	x := make([]float64, corpus.numNonZeros()) // X in the CSR layout of the corpus
+	for d := 0; d < numDocs; d++ {
+		begin, end := corpus.docStart[d], corpus.docStart[d+1]
+		length := float64(0)
+		for i := begin; i < end; i++ {
+			length += corpus.counts[i]
+		}
+		for i := begin; i < end; i++ {
+			x[i] = corpus.counts[i] / length * idf[corpus.wordIds[i]]
+		}
+	}
+	const eps = 1e-9
+	w := randomMatrix(numDocs, numTopics, r)
+	h := randomMatrix(numTopics, numWords, r)
+	gram := newMatrix(numTopics, numTopics)
+	wtx := newMatrix(numTopics, numWords)
+	xht := make([]float64, numTopics)
+	for iter := 0; iter < nmfIterations; iter++ {
+		// H <- H * (W'X) / (W'W H)
+		for z := range wtx {
+			zero(wtx[z])
+		}
+		for d := 0; d < numDocs; d++ {
+			for i := corpus.docStart[d]; i < corpus.docStart[d+1]; i++ {
+				for z := 0; z < numTopics; z++ {
+					wtx[z][corpus.wordIds[i]] += w[d][z] * x[i]
+				}
+			}
+		}
+		for a := 0; a < numTopics; a++ {
+			for b := 0; b < numTopics; b++ {
+				gram[a][b] = 0
+				for d := 0; d < numDocs; d++ {
+					gram[a][b] += w[d][a] * w[d][b]
+				}
+			}
+		}
+		for z := 0; z < numTopics; z++ {
+			for j := 0; j < numWords; j++ {
+				denom := eps
+				for y := 0; y < numTopics; y++ {
+					denom += gram[z][y] * h[y][j]
+				}
+				h[z][j] *= wtx[z][j] / denom
+			}
+		}
+		// W <- W * (X H') / (W H H')
+		for a := 0; a < numTopics; a++ {
+			for b := 0; b < numTopics; b++ {
+				gram[a][b] = 0
+				for j := 0; j < numWords; j++ {
+					gram[a][b] += h[a][j] * h[b][j]
+				}
+			}
+		}
+		for d := 0; d < numDocs; d++ {
+			zero(xht)
+			for i := corpus.docStart[d]; i < corpus.docStart[d+1]; i++ {
+				for z := 0; z < numTopics; z++ {
+					xht[z] += x[i] * h[z][corpus.wordIds[i]]
+				}
+			}
+			for z := 0; z < numTopics; z++ {
+				denom := eps
+				for y := 0; y < numTopics; y++ {
+					denom += w[d][y] * gram[y][z]
+				}
+				w[d][z] *= xht[z] / denom
+			}
+		}
+	}
+	return h
+}
+
+// randomMatrix returns a matrix of positive random values.
+func randomMatrix(rows, cols int, r *rand.Rand) [][]float64 {
+	m := newMatrix(rows, cols)
+	for i := range m {
+		for j := range m[i] {
+			m[i][j] = r.Float64() + 0.01
+		}
+	}
+	return m
+}
+
+// tfidfSample is a document TF-IDF vector implementing
+// kmean.SampleContainer, identified by the index of the document.
+type tfidfSample struct {
+	doc   int
+	terms map[int32]float64
+	norm  float64 // cached Euclidean norm, 0 if not computed yet
+}
+
+func asTfidfSample(c kmean.SampleContainer) *tfidfSample {
+	s, ok := c.(*tfidfSample)
+	if !ok {
+		panic(fmt.Sprintf("expected *tfidfSample but got %v", c))
+	}
+	return s
+}
+
+func (s *tfidfSample) Id() int {
+	return s.doc
+}
+
+func (s *tfidfSample) Equals(c kmean.SampleContainer) bool {
+	return s.doc == asTfidfSample(c).doc
+}
+
+func (s *tfidfSample) DistanceFrom(c kmean.SampleContainer) float64 {
+	a := asTfidfSample(c)
+	dist := float64(0)
+	for k, v := range s.terms {
+		u := a.terms[k]
+		dist += (v - u) * (v - u)
+	}
+	for k, u := range a.terms {
+		if _, found := s.terms[k]; !found {
+			dist += u * u
+		}
+	}
+	return dist
+}
+
+func (s *tfidfSample) CosineSim(c kmean.SampleContainer) float64 {
+	a := asTfidfSample(c)
+	if len(a.terms) < len(s.terms) {
+		s, a = a, s
+	}
+	dot := float64(0)
+	for k, v := range s.terms {
+		dot += v * a.terms[k]
+	}
+	if dot == 0 {
+		return 0
+	}
+	return dot / (s.Norm() * a.Norm())
+}
+
+func (s *tfidfSample) Norm() float64 {
+	if s.norm == 0 {
+		n := float64(0)
+		for _, v := range s.terms {
+			n += v * v
+		}
+		s.norm = math.Sqrt(n)
+	}
+	return s.norm
+}
+
+func (s *tfidfSample) Add(c kmean.SampleContainer) {
+	for k, v := range asTfidfSample(c).terms {
+		s.terms[k] += v
+	}
+	s.norm = 0
+}
+
+func (s *tfidfSample) ScalarMul(a float64) {
+	for k := range s.terms {
+		s.terms[k] *= a
+	}
+	s.norm = 0
+}
+
+func (s *tfidfSample) Zero() kmean.SampleContainer {
+	return &tfidfSample{-1, make(map[int32]float64), 0}
+}
+
+func (s *tfidfSample) Normalize() {
+	if n := s.Norm(); n > 0 {
+		s.ScalarMul(1 / n)
+	}
+}
+
+// tfidfSamples implements kmean.SampleSupplier.
+type tfidfSamples []tfidfSample
+
+func (s tfidfSamples) SampleSize() int {
+	return len(s)
+}
+
+func (s tfidfSamples) Sample(i int) kmean.SampleContainer {
+	return &s[i]
+}
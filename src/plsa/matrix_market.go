@@ -0,0 +1,291 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"bufio"
+	"charset"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Matrices are exchanged in the coordinate format of Matrix Market
+// (https://math.nist.gov/MatrixMarket/formats.html): a header line
+// "%%MatrixMarket matrix coordinate <field> general", optional comment
+// lines starting with "%", a "rows columns entries" line, then one
+// "row column value" line per non-zero entry, numbered from 1. The labels
+// of the rows and of the columns are written to sidecar files holding one
+// label per line, encoded in the given encoding, and are omitted when the
+// file name is empty.
+const matrixMarketBanner = "%%MatrixMarket matrix coordinate"
+
+// WriteCountsMatrixMarket writes the document-word counts of docWordFreq
+// as an integer matrix with one row per document and one column per word.
+// The document ids are written to rowLabelFile and the words to
+// colLabelFile, encoded in enc.
+func WriteCountsMatrixMarket(docWordFreq DocWordFreqRetriever, matrixFile, rowLabelFile, colLabelFile string,
+	enc charset.Encoding) error {
+	w := newExchangeWriter(docWordFreq)
+	docIds := docWordFreq.CorpusIds()
+	err := writeFile(matrixFile, func(out *bufio.Writer) error {
+		words := make([][]int32, len(docIds))
+		counts := make([][]uint64, len(docIds))
+		nonZeros := 0
+		for d, docId := range docIds {
+			words[d], counts[d] = w.docWords(docId)
+			nonZeros += len(words[d])
+		}
+		fmt.Fprintf(out, "%s integer general\n", matrixMarketBanner)
+		fmt.Fprintf(out, "%% document-word counts\n")
+		fmt.Fprintf(out, "%d %d %d\n", len(docIds), docWordFreq.VocabularySize(), nonZeros)
+		for d := range docIds {
+			for i, word := range words[d] {
+				if _, err := fmt.Fprintf(out, "%d %d %d\n", d+1, word+1, counts[d][i]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = writeLabels(rowLabelFile, colLabelFile, docIds, docWordFreq.Vocabulary(), enc)
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("WriteCountsMatrixMarket(%s) failed: %s", matrixFile, err))
+	}
+	return nil
+}
+
+// SaveWordTopicMatrixMarket writes P(w|z) as a real matrix with one row per
+// word and one column per topic. The words are written to rowLabelFile and
+// the topic ids to colLabelFile, encoded in enc.
+func (model *Model) SaveWordTopicMatrixMarket(matrixFile, rowLabelFile, colLabelFile string, enc charset.Encoding) error {
+	err := model.saveFactor(matrixFile, rowLabelFile, colLabelFile, "P(w|z)", model.vocab, model.wordTopicProb, enc)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Model.SaveWordTopicMatrixMarket(%s) failed: %s", matrixFile, err))
+	}
+	return nil
+}
+
+// SaveDocTopicMatrixMarket writes P(d|z) as a real matrix with one row per
+// document and one column per topic. The document ids are written to
+// rowLabelFile and the topic ids to colLabelFile, encoded in enc.
+func (model *Model) SaveDocTopicMatrixMarket(matrixFile, rowLabelFile, colLabelFile string, enc charset.Encoding) error {
+	err := model.saveFactor(matrixFile, rowLabelFile, colLabelFile, "P(d|z)", model.docIds, model.docTopicProb, enc)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Model.SaveDocTopicMatrixMarket(%s) failed: %s", matrixFile, err))
+	}
+	return nil
+}
+
+// saveFactor writes the transpose of probs, which is indexed by [z][row].
+func (model *Model) saveFactor(matrixFile, rowLabelFile, colLabelFile, name string, rows []string, probs [][]float64,
+	enc charset.Encoding) error {
+	err := writeFile(matrixFile, func(out *bufio.Writer) error {
+		nonZeros := 0
+		for z := range probs {
+			for _, p := range probs[z] {
+				if p != 0 {
+					nonZeros++
+				}
+			}
+		}
+		fmt.Fprintf(out, "%s real general\n", matrixMarketBanner)
+		fmt.Fprintf(out, "%% %s\n", name)
+		fmt.Fprintf(out, "%d %d %d\n", len(rows), len(probs), nonZeros)
+		for i := range rows {
+			for z := range probs {
+				if p := probs[z][i]; p != 0 {
+					if _, err := fmt.Fprintf(out, "%d %d %s\n", i+1, z+1, formatProb(p)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	topics := make([]string, len(probs))
+	for z := range topics {
+		topics[z] = strconv.Itoa(z)
+	}
+	return writeLabels(rowLabelFile, colLabelFile, rows, topics, enc)
+}
+
+func writeLabels(rowLabelFile, colLabelFile string, rows, cols []string, enc charset.Encoding) error {
+	if rowLabelFile != "" {
+		if err := writeLines(rowLabelFile, rows, enc); err != nil {
+			return err
+		}
+	}
+	if colLabelFile != "" {
+		return writeLines(colLabelFile, cols, enc)
+	}
+	return nil
+}
+
+// MatrixMarketCorpus is a DocWordFreqRetriever reading document-word
+// counts from a Matrix Market coordinate file with one row per document
+// and one column per word, as written by WriteCountsMatrixMarket. The
+// field may be integer, pattern (every entry counting 1) or real, in which
+// case the values must be whole numbers. Documents are identified by the
+// lines of RowLabelFile and words by the lines of ColLabelFile, or by their
+// number, starting from 1, if the file name is empty. The labels are
+// transcoded from Encoding. Without a label file, the size line may declare
+// at most as many rows or columns as the matrix file has bytes, so that a
+// corrupted size line cannot make the loader allocate without bound.
+type MatrixMarketCorpus struct {
+	RowLabelFile string
+	ColLabelFile string
+	Encoding     charset.Encoding
+	indexedCorpus
+}
+
+func NewMatrixMarketCorpus(rowLabelFile, colLabelFile string) *MatrixMarketCorpus {
+	return &MatrixMarketCorpus{RowLabelFile: rowLabelFile, ColLabelFile: colLabelFile}
+}
+
+func (c *MatrixMarketCorpus) LoadFromFile(matrixFile string) error {
+	if err := c.load(matrixFile); err != nil {
+		return errors.New(fmt.Sprintf("MatrixMarketCorpus.LoadFromFile(%s) failed: %s", matrixFile, err))
+	}
+	return nil
+}
+
+// labels returns the lines of the given file, or the numbers from 1 to n if
+// filename is empty, in which case n may not exceed limit.
+func (c *MatrixMarketCorpus) labels(filename string, n, limit uint64) ([]string, error) {
+	if filename == "" {
+		if n > limit {
+			return nil, errors.New(fmt.Sprintf("%d rows or columns declared in a file of %d bytes", n, limit))
+		}
+		labels := make([]string, n)
+		for i := range labels {
+			labels[i] = strconv.Itoa(i + 1)
+		}
+		return labels, nil
+	}
+	labels, err := readLines(filename, c.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(labels)) != n {
+		return nil, errors.New(fmt.Sprintf("%s has %d labels but the matrix has %d", filename, len(labels), n))
+	}
+	return labels, nil
+}
+
+func (c *MatrixMarketCorpus) load(matrixFile string) error {
+	fileSize, err := sizeOf(matrixFile)
+	if err != nil {
+		return err
+	}
+	var field string
+	var size []uint64
+	words := make(map[uint64][]int32)
+	counts := make(map[uint64][]uint64)
+	entries := uint64(0)
+	err = forEachDataLine(matrixFile, func(lineNo int, fields []string) error {
+		if lineNo == 1 {
+			banner := strings.ToLower(strings.Join(fields, " "))
+			if len(fields) != 5 || !strings.HasPrefix(banner, strings.ToLower(matrixMarketBanner)+" ") {
+				return errors.New("not a Matrix Market coordinate file")
+			}
+			field = strings.ToLower(fields[3])
+			if field != "integer" && field != "real" && field != "pattern" {
+				return errors.New(fmt.Sprintf("unsupported field [%s]", fields[3]))
+			}
+			if strings.ToLower(fields[4]) != "general" {
+				return errors.New(fmt.Sprintf("unsupported symmetry [%s]", fields[4]))
+			}
+			return nil
+		}
+		if field == "" {
+			return errors.New("not a Matrix Market coordinate file")
+		}
+		if strings.HasPrefix(fields[0], "%") {
+			return nil
+		}
+		if size == nil {
+			if len(fields) != 3 {
+				return errors.New("expected \"rows columns entries\"")
+			}
+			var err error
+			size, err = parseUints(fields)
+			return err
+		}
+		entries++
+		return c.entry(fields, field, size, words, counts)
+	})
+	if err != nil {
+		return err
+	}
+	if size == nil {
+		return errors.New("missing size line")
+	}
+	if entries != size[2] {
+		return errors.New(fmt.Sprintf("%d entries declared but %d found", size[2], entries))
+	}
+	docIds, err := c.labels(c.RowLabelFile, size[0], fileSize)
+	if err != nil {
+		return err
+	}
+	vocab, err := c.labels(c.ColLabelFile, size[1], fileSize)
+	if err != nil {
+		return err
+	}
+	c.reset(vocab)
+	for d, docId := range docIds {
+		if err := c.addDoc(docId, words[uint64(d)], counts[uint64(d)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entry parses an entry line and adds it to words and counts.
+func (c *MatrixMarketCorpus) entry(fields []string, field string, size []uint64, words map[uint64][]int32,
+	counts map[uint64][]uint64) error {
+	n := 3
+	if field == "pattern" {
+		n = 2
+	}
+	if len(fields) != n {
+		return errors.New(fmt.Sprintf("expected %d values", n))
+	}
+	index, err := parseUints(fields[:2])
+	if err != nil {
+		return err
+	}
+	row, col := index[0], index[1]
+	if row < 1 || row > size[0] || col < 1 || col > size[1] || col > math.MaxInt32 {
+		return errors.New("row or column out of range")
+	}
+	count := uint64(1)
+	switch field {
+	case "integer":
+		count, err = strconv.ParseUint(fields[2], 10, 64)
+	case "real":
+		var v float64
+		v, err = strconv.ParseFloat(fields[2], 64)
+		if err == nil && (v < 0 || v != math.Floor(v) || v > math.MaxUint32) {
+			err = errors.New(fmt.Sprintf("[%s] is not a count", fields[2]))
+		}
+		count = uint64(v)
+	}
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		words[row-1] = append(words[row-1], int32(col-1))
+		counts[row-1] = append(counts[row-1], count)
+	}
+	return nil
+}
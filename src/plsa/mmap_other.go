@@ -0,0 +1,21 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package plsa
+
+import (
+	"io/ioutil"
+)
+
+// mapFile reads the whole file into memory on the platforms where it is not
+// memory-mapped.
+func mapFile(filename string) ([]byte, func() error, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
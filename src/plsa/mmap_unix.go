@@ -0,0 +1,34 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package plsa
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the given file read-only into memory and returns its content
+// together with the function releasing the mapping.
+func mapFile(filename string) ([]byte, func() error, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fd.Close()
+	info, err := fd.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Size() == 0 {
+		return nil, func() error { return nil }, nil
+	}
+	data, err := syscall.Mmap(int(fd.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,512 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The on-disk model format is a line oriented text file:
+//
+//	plsa-model <version>
+//	topics <number of topics>
+//	words <vocabulary size>
+//	docs <number of documents>
+//	param <name> <value>          (one line per training parameter, one per word for WordPriors)
+//	stop <quoted reason>          (why training stopped, if known)
+//	restarts <n> <selected>       (if selected from n restarts, followed by n lines:)
+//	r <seed> <likelihood> <held-out perplexity, or - without held-out corpus>
+//	vocabulary
+//	<quoted word>                 (one line per word)
+//	documents
+//	<quoted document id>          (one line per document)
+//	topic <topic id> <P(z)>       (for each topic, followed by:)
+//	w <P(w|z) for each word, in vocabulary order>
+//	d <P(d|z) for each document, in document order>
+//	end
+//
+// Words and document ids are written as Go quoted strings so that they
+// may contain arbitrary characters. The file is always UTF-8 encoded.
+//
+// Version 1 stores the probabilities in float32 precision and has no stop
+// and restarts lines. Since version 2, param lines of unknown parameters
+// are skipped, so that parameters can be added without changing the
+// version; any other change of the format requires a new version.
+const (
+	modelFormatName       = "plsa-model"
+	modelFormatVersion    = 2
+	minModelFormatVersion = 1
+)
+
+// SaveToFile saves the PLSA model to the given file.
+func (model *Model) SaveToFile(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	err = model.write(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if cErr := file.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
+
+func (model *Model) write(w *bufio.Writer) error {
+	fmt.Fprintf(w, "%s %d\n", modelFormatName, modelFormatVersion)
+	fmt.Fprintf(w, "topics %d\n", len(model.topicProb))
+	fmt.Fprintf(w, "words %d\n", len(model.vocab))
+	fmt.Fprintf(w, "docs %d\n", len(model.docIds))
+	fmt.Fprintf(w, "param NumberOfTopics %d\n", model.param.NumberOfTopics)
+	fmt.Fprintf(w, "param LikelihoodIncLimit %s\n", formatProb(model.param.LikelihoodIncLimit))
+	fmt.Fprintf(w, "param MaxIteration %d\n", model.param.MaxIteration)
+	fmt.Fprintf(w, "param Workers %d\n", model.param.Workers)
+	fmt.Fprintf(w, "param Beta %s\n", formatProb(model.param.Beta))
+	fmt.Fprintf(w, "param BetaDecay %s\n", formatProb(model.param.BetaDecay))
+	fmt.Fprintf(w, "param MinBeta %s\n", formatProb(model.param.MinBeta))
+	fmt.Fprintf(w, "param Seed %d\n", model.param.Seed)
+	fmt.Fprintf(w, "param Restarts %d\n", model.param.Restarts)
+	fmt.Fprintf(w, "param RestartWorkers %d\n", model.param.RestartWorkers)
+	fmt.Fprintf(w, "param Init %s\n", model.param.Init)
+	fmt.Fprintf(w, "param InitSampleSize %d\n", model.param.InitSampleSize)
+	fmt.Fprintf(w, "param LogSpace %t\n", model.param.LogSpace)
+	fmt.Fprintf(w, "param Debug %t\n", model.param.Debug)
+	fmt.Fprintf(w, "param CheckpointEvery %d\n", model.param.CheckpointEvery)
+	fmt.Fprintf(w, "param CheckpointInterval %s\n", model.param.CheckpointInterval)
+	if r := model.param.Regularization; r != nil {
+		fmt.Fprintf(w, "param WordPrior %s\n", formatProb(r.WordPrior))
+		words := make([]string, 0, len(r.WordPriors))
+		for word := range r.WordPriors {
+			words = append(words, word)
+		}
+		sort.Strings(words)
+		for _, word := range words {
+			fmt.Fprintf(w, "param WordPriors %s %s\n", strconv.Quote(word), formatProb(r.WordPriors[word]))
+		}
+		fmt.Fprintf(w, "param TopicPrior %s\n", formatProb(r.TopicPrior))
+		fmt.Fprintf(w, "param TopicPriors %s\n", formatProbs(r.TopicPriors))
+		fmt.Fprintf(w, "param WordSparsity %s\n", formatProbs(r.WordSparsity))
+		fmt.Fprintf(w, "param DocSparsity %s\n", formatProbs(r.DocSparsity))
+	}
+	if model.stopReason != "" {
+		fmt.Fprintf(w, "stop %s\n", strconv.Quote(model.stopReason))
+	}
+	if s := model.restarts; s != nil {
+		fmt.Fprintf(w, "restarts %d %d\n", len(s.Seeds), s.Best)
+		for i, seed := range s.Seeds {
+			perplexity := "-"
+			if s.Perplexities != nil {
+				perplexity = formatProb(s.Perplexities[i])
+			}
+			fmt.Fprintf(w, "r %d %s %s\n", seed, formatProb(s.Likelihoods[i]), perplexity)
+		}
+	}
+	fmt.Fprintf(w, "vocabulary\n")
+	for _, word := range model.vocab {
+		fmt.Fprintf(w, "%s\n", strconv.Quote(word))
+	}
+	fmt.Fprintf(w, "documents\n")
+	for _, docId := range model.docIds {
+		fmt.Fprintf(w, "%s\n", strconv.Quote(docId))
+	}
+	for z, p := range model.topicProb {
+		fmt.Fprintf(w, "topic %d %s\n", z, formatProb(p))
+		w.WriteString("w")
+		for _, p := range model.wordTopicProb[z] {
+			w.WriteString(" " + formatProb(p))
+		}
+		w.WriteString("\nd")
+		for _, p := range model.docTopicProb[z] {
+			w.WriteString(" " + formatProb(p))
+		}
+		w.WriteString("\n")
+	}
+	_, err := fmt.Fprintf(w, "end\n")
+	return err
+}
+
+func formatProb(p float64) string {
+	return strconv.FormatFloat(p, 'g', -1, 64)
+}
+
+// formatProbs formats the values separated by single spaces, or as "-" if
+// there is none.
+func formatProbs(values []float64) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = formatProb(v)
+	}
+	return strings.Join(s, " ")
+}
+
+func parseProbs(s string) ([]float64, error) {
+	if s == "-" {
+		return nil, nil
+	}
+	fields := strings.Fields(s)
+	values := make([]float64, len(fields))
+	for i, f := range fields {
+		var err error
+		if values[i], err = strconv.ParseFloat(f, 64); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// LoadModelFromFile loads a PLSA model from the given path.
+// An error is returned if the file is not a PLSA model of the supported
+// format version, or if it is truncated or otherwise malformed.
+func LoadModelFromFile(filename string) (*Model, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	model, err := readModel(&modelReader{reader: bufio.NewReader(file)})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("LoadModelFromFile(%s): %s", filename, err))
+	}
+	return model, nil
+}
+
+// modelReader reads the model file line by line, keeping track of the
+// line number for error reporting.
+type modelReader struct {
+	reader *bufio.Reader
+	lineNo int
+}
+
+func (r *modelReader) next() (string, error) {
+	line, err := r.reader.ReadString('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	if err == io.EOF {
+		return "", errors.New(fmt.Sprintf("unexpected end of file after line %d, the file is truncated", r.lineNo))
+	} else if err != nil {
+		return "", err
+	}
+	r.lineNo++
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (r *modelReader) errorf(format string, a ...interface{}) error {
+	return errors.New(fmt.Sprintf("line %d: ", r.lineNo) + fmt.Sprintf(format, a...))
+}
+
+// fields reads the next line and splits it into fields, checking that
+// it starts with the given keyword and has exactly n fields.
+func (r *modelReader) fields(keyword string, n int) ([]string, error) {
+	line, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	f := strings.Fields(line)
+	if len(f) == 0 || f[0] != keyword {
+		return nil, r.errorf("expected [%s] but got [%s]", keyword, line)
+	}
+	if n >= 0 && len(f) != n {
+		return nil, r.errorf("expected %d fields in [%s] line but got %d", n, keyword, len(f))
+	}
+	return f, nil
+}
+
+func (r *modelReader) count(keyword string) (int, error) {
+	f, err := r.fields(keyword, 2)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(f[1])
+	if err != nil || n < 0 {
+		return 0, r.errorf("invalid %s count [%s]", keyword, f[1])
+	}
+	return n, nil
+}
+
+// section reads a keyword line followed by n quoted strings.
+func (r *modelReader) section(keyword string, n int) ([]string, error) {
+	if _, err := r.fields(keyword, 1); err != nil {
+		return nil, err
+	}
+	return r.quoted(n)
+}
+
+// quoted reads the next n lines as quoted strings. The slice grows as the
+// lines are read, so a corrupt count fails at the end of the file instead
+// of allocating memory for it.
+func (r *modelReader) quoted(n int) ([]string, error) {
+	values := []string{}
+	for i := 0; i < n; i++ {
+		line, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		value, err := strconv.Unquote(line)
+		if err != nil {
+			return nil, r.errorf("invalid quoted string [%s]", line)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (r *modelReader) probs(keyword string, n int) ([]float64, error) {
+	f, err := r.fields(keyword, n+1)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]float64, n)
+	for i := range values {
+		var err error
+		if values[i], err = strconv.ParseFloat(f[i+1], 64); err != nil {
+			return nil, r.errorf("invalid probability [%s]", f[i+1])
+		}
+	}
+	return values, nil
+}
+
+func readModel(r *modelReader) (*Model, error) {
+	header, err := r.fields(modelFormatName, 2)
+	if err != nil {
+		return nil, errors.New("not a PLSA model file: " + err.Error())
+	}
+	version, err := strconv.Atoi(header[1])
+	if err != nil || version < minModelFormatVersion || version > modelFormatVersion {
+		return nil, r.errorf("unsupported model format version [%s], expected %d to %d",
+			header[1], minModelFormatVersion, modelFormatVersion)
+	}
+	numTopics, err := r.count("topics")
+	if err != nil {
+		return nil, err
+	}
+	numWords, err := r.count("words")
+	if err != nil {
+		return nil, err
+	}
+	numDocs, err := r.count("docs")
+	if err != nil {
+		return nil, err
+	}
+
+	var m Model
+	for {
+		line, err := r.next()
+		if err != nil {
+			return nil, err
+		}
+		if line == "vocabulary" {
+			break
+		}
+		// The value is the rest of the line, it may contain spaces.
+		f := strings.SplitN(line, " ", 3)
+		switch {
+		case f[0] == "stop" && version > 1:
+			quoted := strings.TrimPrefix(line, "stop ")
+			if m.stopReason, err = strconv.Unquote(quoted); err != nil {
+				return nil, r.errorf("invalid quoted string [%s]", quoted)
+			}
+		case len(f) == 3 && f[0] == "restarts" && version > 1:
+			if m.restarts, err = r.restarts(f[1], f[2]); err != nil {
+				return nil, err
+			}
+		case len(f) == 3 && f[0] == "param":
+			err := m.param.set(f[1], f[2])
+			if err == errUnknownParameter {
+				if version > 1 {
+					continue
+				}
+				return nil, r.errorf("%s [%s]", err, f[1])
+			}
+			if err != nil {
+				return nil, r.errorf("%s", err)
+			}
+		default:
+			return nil, r.errorf("expected [param] or [vocabulary] but got [%s]", line)
+		}
+	}
+	// The vocabulary keyword has already been consumed.
+	if m.vocab, err = r.quoted(numWords); err != nil {
+		return nil, err
+	}
+	if m.docIds, err = r.section("documents", numDocs); err != nil {
+		return nil, err
+	}
+
+	// The topics are appended as they are read, like the quoted strings.
+	for z := 0; z < numTopics; z++ {
+		f, err := r.fields("topic", 3)
+		if err != nil {
+			return nil, err
+		}
+		if id, err := strconv.Atoi(f[1]); err != nil || id != z {
+			return nil, r.errorf("expected topic %d but got [%s]", z, f[1])
+		}
+		p_z, err := strconv.ParseFloat(f[2], 64)
+		if err != nil {
+			return nil, r.errorf("invalid topic probability [%s]", f[2])
+		}
+		wordProbs, err := r.probs("w", numWords)
+		if err != nil {
+			return nil, err
+		}
+		docProbs, err := r.probs("d", numDocs)
+		if err != nil {
+			return nil, err
+		}
+		m.topicProb = append(m.topicProb, p_z)
+		m.wordTopicProb = append(m.wordTopicProb, wordProbs)
+		m.docTopicProb = append(m.docTopicProb, docProbs)
+	}
+	if _, err := r.fields("end", 1); err != nil {
+		return nil, err
+	}
+	m.buildIndex()
+	return &m, nil
+}
+
+// restarts reads the restart lines following a "restarts <n> <selected>"
+// line.
+func (r *modelReader) restarts(n, selected string) (*RestartSummary, error) {
+	count, err := strconv.Atoi(n)
+	if err != nil || count < 1 {
+		return nil, r.errorf("invalid restarts count [%s]", n)
+	}
+	s := &RestartSummary{}
+	if s.Best, err = strconv.Atoi(selected); err != nil || s.Best < 0 || s.Best >= count {
+		return nil, r.errorf("invalid selected restart [%s]", selected)
+	}
+	var perplexities []float64
+	for i := 0; i < count; i++ {
+		f, err := r.fields("r", 4)
+		if err != nil {
+			return nil, err
+		}
+		seed, err := strconv.ParseInt(f[1], 10, 64)
+		if err != nil {
+			return nil, r.errorf("invalid seed [%s]", f[1])
+		}
+		likelihood, err := strconv.ParseFloat(f[2], 64)
+		if err != nil {
+			return nil, r.errorf("invalid likelihood [%s]", f[2])
+		}
+		s.Seeds = append(s.Seeds, seed)
+		s.Likelihoods = append(s.Likelihoods, likelihood)
+		if f[3] == "-" {
+			continue
+		}
+		perplexity, err := strconv.ParseFloat(f[3], 64)
+		if err != nil {
+			return nil, r.errorf("invalid held-out perplexity [%s]", f[3])
+		}
+		perplexities = append(perplexities, perplexity)
+	}
+	if len(perplexities) != 0 && len(perplexities) != count {
+		return nil, r.errorf("held-out perplexity given for %d of %d restarts", len(perplexities), count)
+	}
+	s.Perplexities = perplexities
+	return s, nil
+}
+
+// regularization returns param.Regularization, creating it if necessary.
+func (param *TrainingParameter) regularization() *Regularization {
+	if param.Regularization == nil {
+		param.Regularization = &Regularization{}
+	}
+	return param.Regularization
+}
+
+// setWordPrior sets the prior of a word from its representation
+// "<quoted word> <pseudo-count>" in the model file.
+func (r *Regularization) setWordPrior(value string) error {
+	quoted, err := strconv.QuotedPrefix(value)
+	if err != nil {
+		return err
+	}
+	word, _ := strconv.Unquote(quoted)
+	p, err := strconv.ParseFloat(strings.TrimPrefix(value[len(quoted):], " "), 64)
+	if err != nil {
+		return err
+	}
+	if r.WordPriors == nil {
+		r.WordPriors = make(map[string]float64)
+	}
+	r.WordPriors[word] = p
+	return nil
+}
+
+var errUnknownParameter = errors.New("unknown training parameter")
+
+// set assigns the training parameter of the given name from its
+// string representation in the model file.
+func (param *TrainingParameter) set(name, value string) error {
+	var err error
+	switch name {
+	case "NumberOfTopics":
+		param.NumberOfTopics, err = strconv.Atoi(value)
+	case "LikelihoodIncLimit":
+		param.LikelihoodIncLimit, err = strconv.ParseFloat(value, 64)
+	case "Beta":
+		param.Beta, err = strconv.ParseFloat(value, 64)
+	case "BetaDecay":
+		param.BetaDecay, err = strconv.ParseFloat(value, 64)
+	case "MinBeta":
+		param.MinBeta, err = strconv.ParseFloat(value, 64)
+	case "MaxIteration":
+		param.MaxIteration, err = strconv.Atoi(value)
+	case "Workers":
+		param.Workers, err = strconv.Atoi(value)
+	case "Seed":
+		param.Seed, err = strconv.ParseInt(value, 10, 64)
+	case "Restarts":
+		param.Restarts, err = strconv.Atoi(value)
+	case "RestartWorkers":
+		param.RestartWorkers, err = strconv.Atoi(value)
+	case "Init":
+		param.Init, err = ParseInitializer(value)
+	case "InitSampleSize":
+		param.InitSampleSize, err = strconv.Atoi(value)
+	case "LogSpace":
+		param.LogSpace, err = strconv.ParseBool(value)
+	case "Debug":
+		param.Debug, err = strconv.ParseBool(value)
+	case "CheckpointEvery":
+		param.CheckpointEvery, err = strconv.Atoi(value)
+	case "CheckpointInterval":
+		param.CheckpointInterval, err = time.ParseDuration(value)
+	case "WordPrior":
+		param.regularization().WordPrior, err = strconv.ParseFloat(value, 64)
+	case "WordPriors":
+		err = param.regularization().setWordPrior(value)
+	case "TopicPrior":
+		param.regularization().TopicPrior, err = strconv.ParseFloat(value, 64)
+	case "TopicPriors":
+		param.regularization().TopicPriors, err = parseProbs(value)
+	case "WordSparsity":
+		param.regularization().WordSparsity, err = parseProbs(value)
+	case "DocSparsity":
+		param.regularization().DocSparsity, err = parseProbs(value)
+	default:
+		return errUnknownParameter
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("invalid value [%s] for training parameter [%s]", value, name))
+	}
+	return nil
+}
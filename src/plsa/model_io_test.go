@@ -0,0 +1,237 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"charset"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testModel() *Model {
+	m := &Model{
+		topicProb:     []float64{0.25, 0.75},
+		docTopicProb:  [][]float64{{0.5, 0.5}, {0.125, 0.875}},
+		wordTopicProb: [][]float64{{0.1, 0.2, 0.7}, {0.3, 0.3, 0.4}},
+		vocab:         []string{"鲜花", "快递", "游戏"},
+		docIds:        []string{"doc 1", "doc2"},
+		param:         TrainingParameter{NumberOfTopics: 2, LikelihoodIncLimit: 0.001, MaxIteration: 50, Seed: 1234567890123},
+	}
+	m.buildIndex()
+	return m
+}
+
+func TestModelSaveAndLoad(t *testing.T) {
+	testFile := "plsa_model_test.txt"
+	defer func() {
+		os.Remove(testFile)
+	}()
+	m := testModel()
+	if err := m.SaveToFile(testFile); err != nil {
+		t.Fatalf("Model.SaveToFile(%s) failed: %s", testFile, err)
+	}
+	l, err := LoadModelFromFile(testFile)
+	if err != nil {
+		t.Fatalf("LoadModelFromFile(%s) failed: %s", testFile, err)
+	}
+	if l.param != m.param {
+		t.Errorf("Expected training parameter %v but got %v.", m.param, l.param)
+	}
+	if l.NumberOfTopics() != m.NumberOfTopics() {
+		t.Fatalf("Expected %d topics but got %d.", m.NumberOfTopics(), l.NumberOfTopics())
+	}
+	for z := 0; z < m.NumberOfTopics(); z++ {
+		if l.TopicProbability(z) != m.TopicProbability(z) {
+			t.Errorf("P(z=%d): expected %f but got %f.", z, m.TopicProbability(z), l.TopicProbability(z))
+		}
+		for _, w := range m.vocab {
+			if l.WordProbabilityGivenTopic(w, z) != m.WordProbabilityGivenTopic(w, z) {
+				t.Errorf("P(w=%s|z=%d): expected %f but got %f.", w, z,
+					m.WordProbabilityGivenTopic(w, z), l.WordProbabilityGivenTopic(w, z))
+			}
+		}
+		for _, d := range m.docIds {
+			if l.DocProbabilityGivenTopic(d, z) != m.DocProbabilityGivenTopic(d, z) {
+				t.Errorf("P(d=%s|z=%d): expected %f but got %f.", d, z,
+					m.DocProbabilityGivenTopic(d, z), l.DocProbabilityGivenTopic(d, z))
+			}
+		}
+	}
+}
+
+func TestSaveRegularization(t *testing.T) {
+	testFile := "plsa_model_test.txt"
+	defer func() {
+		os.Remove(testFile)
+	}()
+	m := testModel()
+	r := &Regularization{WordPrior: 0.01, WordPriors: map[string]float64{"a b": 2, "\"c\"": 0.5},
+		TopicPrior: 0.1, TopicPriors: []float64{0.2, 0.3}, DocSparsity: []float64{1, 0}}
+	(*m).param.Regularization = r
+	if err := m.SaveToFile(testFile); err != nil {
+		t.Fatalf("Model.SaveToFile(%s) failed: %s", testFile, err)
+	}
+	l, err := LoadModelFromFile(testFile)
+	if err != nil {
+		t.Fatalf("LoadModelFromFile(%s) failed: %s", testFile, err)
+	}
+	if !reflect.DeepEqual(l.param.Regularization, r) {
+		t.Errorf("Expected regularization %v but got %v.", r, l.param.Regularization)
+	}
+}
+
+func TestSaveTrainingSummary(t *testing.T) {
+	testFile := "plsa_model_test.txt"
+	defer func() {
+		os.Remove(testFile)
+	}()
+	m := testModel()
+	(*m).stopReason = "reached 50 iterations"
+	(*m).restarts = &RestartSummary{Seeds: []int64{7, 8}, Likelihoods: []float64{-12.5, -11.25},
+		Perplexities: []float64{30.5, 29}, Best: 1}
+	if err := m.SaveToFile(testFile); err != nil {
+		t.Fatalf("Model.SaveToFile(%s) failed: %s", testFile, err)
+	}
+	l, err := LoadModelFromFile(testFile)
+	if err != nil {
+		t.Fatalf("LoadModelFromFile(%s) failed: %s", testFile, err)
+	}
+	if l.StopReason() != m.StopReason() {
+		t.Errorf("Expected stop reason [%s] but got [%s].", m.StopReason(), l.StopReason())
+	}
+	if !reflect.DeepEqual(l.RestartSummary(), m.RestartSummary()) {
+		t.Errorf("Expected restart summary %v but got %v.", m.RestartSummary(), l.RestartSummary())
+	}
+}
+
+func TestLoadOlderModelFormat(t *testing.T) {
+	testFile := "plsa_model_test.txt"
+	defer func() {
+		os.Remove(testFile)
+	}()
+	if err := testModel().SaveToFile(testFile); err != nil {
+		t.Fatalf("Model.SaveToFile(%s) failed: %s", testFile, err)
+	}
+	content, err := ioutil.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %s", testFile, err)
+	}
+	withUnknown := strings.Replace(string(content), "vocabulary\n", "param Unknown 1\nvocabulary\n", 1)
+	cases := []struct {
+		content string
+		valid   bool
+	}{
+		{strings.Replace(string(content), "plsa-model 2", "plsa-model 1", 1), true},
+		{withUnknown, true},
+		{strings.Replace(withUnknown, "plsa-model 2", "plsa-model 1", 1), false},
+	}
+	for i, c := range cases {
+		if err := ioutil.WriteFile(testFile, []byte(c.content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %s", testFile, err)
+		}
+		if _, err := LoadModelFromFile(testFile); (err == nil) != c.valid {
+			t.Errorf("Case %d: expected success %t but got error %v.", i, c.valid, err)
+		}
+	}
+}
+
+func TestLoadModelRejectsBadFiles(t *testing.T) {
+	testFile := "plsa_model_test.txt"
+	defer func() {
+		os.Remove(testFile)
+	}()
+	if err := testModel().SaveToFile(testFile); err != nil {
+		t.Fatalf("Model.SaveToFile(%s) failed: %s", testFile, err)
+	}
+	content, err := ioutil.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %s", testFile, err)
+	}
+	cases := map[string]string{
+		"version":   strings.Replace(string(content), "plsa-model 2", "plsa-model 999", 1),
+		"truncated": string(content[:len(content)-20]),
+		"empty":     "",
+		"header":    "plsa-model 2\ntopics 1\nwords 999999999999\ndocs 1\nvocabulary\n",
+	}
+	for name, c := range cases {
+		if err := ioutil.WriteFile(testFile, []byte(c), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %s", testFile, err)
+		}
+		if _, err := LoadModelFromFile(testFile); err == nil {
+			t.Errorf("Expected LoadModelFromFile to fail on %s file.", name)
+		}
+	}
+}
+
+func TestMatrixMarket(t *testing.T) {
+	matrixFile, rowFile, colFile := "matrix_market_test.mtx", "matrix_market_test.rows", "matrix_market_test.cols"
+	defer func() {
+		os.Remove(matrixFile)
+		os.Remove(rowFile)
+		os.Remove(colFile)
+	}()
+	m := testModel()
+	if err := m.SaveWordTopicMatrixMarket(matrixFile, rowFile, colFile, charset.UTF8); err != nil {
+		t.Fatalf("Model.SaveWordTopicMatrixMarket failed: %s", err)
+	}
+	content, _ := ioutil.ReadFile(matrixFile)
+	expected := "%%MatrixMarket matrix coordinate real general\n% P(w|z)\n3 2 6\n" +
+		"1 1 0.1\n1 2 0.3\n2 1 0.2\n2 2 0.3\n3 1 0.7\n3 2 0.4\n"
+	if string(content) != expected {
+		t.Errorf("Expected P(w|z) matrix\n%s\nbut got\n%s", expected, content)
+	}
+	if rows, _ := ioutil.ReadFile(rowFile); string(rows) != "鲜花\n快递\n游戏\n" {
+		t.Errorf("Unexpected row labels %q.", rows)
+	}
+
+	corpus := twoTopicCorpus()
+	if err := WriteCountsMatrixMarket(corpus, matrixFile, rowFile, colFile, charset.UTF8); err != nil {
+		t.Fatalf("WriteCountsMatrixMarket failed: %s", err)
+	}
+	loaded := NewMatrixMarketCorpus(rowFile, colFile)
+	if err := loaded.LoadFromFile(matrixFile); err != nil {
+		t.Fatalf("MatrixMarketCorpus.LoadFromFile failed: %s", err)
+	}
+	if !sameCorpus(corpus, loaded) {
+		t.Errorf("Matrix Market round trip changed the corpus.")
+	}
+
+	content = []byte("%%MatrixMarket matrix coordinate pattern general\n% comment\n2 3 2\n1 3\n2 1\n")
+	ioutil.WriteFile(matrixFile, content, 0644)
+	loaded = NewMatrixMarketCorpus("", "")
+	if err := loaded.LoadFromFile(matrixFile); err != nil {
+		t.Fatalf("MatrixMarketCorpus.LoadFromFile failed on pattern matrix: %s", err)
+	}
+	if loaded.DocWordCount("1", "3") != 1 || loaded.DocWordCount("2", "1") != 1 || loaded.VocabularySize() != 3 {
+		t.Errorf("Unexpected pattern matrix content.")
+	}
+	ioutil.WriteFile(matrixFile, []byte("%%MatrixMarket matrix coordinate real general\n1 1 1\n1 1 0.5\n"), 0644)
+	if err := loaded.LoadFromFile(matrixFile); err == nil {
+		t.Errorf("Expected fractional counts to be rejected.")
+	}
+	ioutil.WriteFile(matrixFile, []byte("%%MatrixMarket matrix coordinate integer general\n18446744073709551615 1 1\n1 1 1\n"), 0644)
+	if err := loaded.LoadFromFile(matrixFile); err == nil {
+		t.Errorf("Expected a huge size line to be rejected.")
+	}
+}
+
+func TestWriteTopWords(t *testing.T) {
+	var b strings.Builder
+	if err := testModel().WriteTopWords(&b, 2, false); err != nil {
+		t.Fatalf("Model.WriteTopWords failed: %s", err)
+	}
+	expected := "0 0.250000 游戏 0.700000 快递 0.200000 \n1 0.750000 游戏 0.400000 鲜花 0.300000 \n"
+	if b.String() != expected {
+		t.Errorf("Expected\n%s\nbut got\n%s", expected, b.String())
+	}
+	b.Reset()
+	testModel().WriteTopWords(&b, 1, true)
+	if expected := "0 0.250000 游戏 1.000000 \n1 0.750000 游戏 1.000000 \n"; b.String() != expected {
+		t.Errorf("Expected renormalized\n%s\nbut got\n%s", expected, b.String())
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// DefaultBatchIterations is the maximum number of EM iterations run on each
+// batch after the first one when OnlineParameter.BatchIterations is 0.
+const DefaultBatchIterations = 20
+
+// NewWordPseudoCount bounds the random expected count n(w,z) a word gets in
+// each topic when it first appears in a batch, so that EM can assign it to
+// the topics.
+const NewWordPseudoCount = 0.01
+
+// OnlineParameter holds the parameter for training a PLSA model
+// incrementally with an OnlineTrainer.
+type OnlineParameter struct {
+	// Parameter of the EM training on the first batch. NumberOfTopics,
+	// LikelihoodIncLimit, Workers, LogSpace and Seed also apply to the
+	// later batches.
+	TrainingParameter
+	Decay           float64 // Factor in (0, 1] the statistics of the earlier batches are multiplied by when a batch is added, 0 means 1.
+	BatchIterations int     // Maximum number of EM iterations on each later batch, 0 means DefaultBatchIterations.
+}
+
+// OnlineTrainer trains a PLSA model on a corpus arriving in batches, such
+// as a corpus growing daily, without retraining on the earlier batches.
+//
+// The first batch is trained with TrainFromData. For each later batch, EM
+// is run on the documents of the batch alone: the E-step is the usual one,
+// and the M-step estimates P(w|z) and P(z) from the expected counts n(w,z)
+// and n(z) of the batch added to those of the earlier batches multiplied
+// by Decay, which are kept as sufficient statistics instead of the earlier
+// documents. A Decay below 1 lets the topics follow a drifting corpus.
+// The documents of the batch are added to the model, and so are the words
+// not seen before. P(d|z) of earlier documents keeps their share of the
+// decayed n(z).
+type OnlineTrainer struct {
+	param     OnlineParameter
+	model     *Model      // nil until the first batch
+	wordTopic [][]float64 // decayed n(w,z), indexed by [z][w]
+	docTopic  [][]float64 // decayed n(d,z), indexed by [z][d]
+	topic     []float64   // decayed n(z)
+	rng       *rand.Rand  // source of the pseudo counts of new words
+	batches   int
+}
+
+// NewOnlineTrainer returns a trainer starting without any document.
+func NewOnlineTrainer(param *OnlineParameter) *OnlineTrainer {
+	t := &OnlineTrainer{param: *param}
+	if t.param.Seed == 0 {
+		t.param.Seed = time.Now().UnixNano()
+	}
+	if t.param.Decay <= 0 {
+		t.param.Decay = 1
+	}
+	if t.param.BatchIterations <= 0 {
+		t.param.BatchIterations = DefaultBatchIterations
+	}
+	t.rng = rand.New(rand.NewSource(t.param.Seed))
+	return t
+}
+
+// NewOnlineTrainerFromModel returns a trainer continuing from the given
+// model, such as one trained with TrainFromData or saved from an earlier
+// OnlineTrainer. The model stands for weight word occurrences, normally the
+// total count of its training corpus: the larger the weight, the less a
+// batch changes the model. NumberOfTopics is taken from the model.
+func NewOnlineTrainerFromModel(model *Model, weight float64, param *OnlineParameter) *OnlineTrainer {
+	t := NewOnlineTrainer(param)
+	t.param.NumberOfTopics = model.NumberOfTopics()
+	t.init(model, weight)
+	return t
+}
+
+// init sets the sufficient statistics to the expected counts of a corpus
+// of the given total count under the model.
+func (t *OnlineTrainer) init(model *Model, weight float64) {
+	m := model.clone()
+	(*m).vocab = append([]string(nil), model.vocab...)
+	(*m).docIds = append([]string(nil), model.docIds...)
+	m.buildIndex()
+	t.model = m
+	numTopics := m.NumberOfTopics()
+	t.topic = make([]float64, numTopics)
+	t.wordTopic = newMatrix(numTopics, len(m.vocab))
+	t.docTopic = newMatrix(numTopics, len(m.docIds))
+	for z, pz := range m.topicProb {
+		t.topic[z] = pz * weight
+		for w, p := range m.wordTopicProb[z] {
+			t.wordTopic[z][w] = p * t.topic[z]
+		}
+		for d, p := range m.docTopicProb[z] {
+			t.docTopic[z][d] = p * t.topic[z]
+		}
+	}
+	t.batches++
+}
+
+// Update trains the model on a batch of documents, which must not be in
+// the model yet. The batch is checked before the trainer is changed, so
+// the trainer is left as it was if an error is returned.
+func (t *OnlineTrainer) Update(batch DocWordFreqRetriever) error {
+	if err := t.checkBatch(batch); err != nil {
+		return errors.New(fmt.Sprintf("OnlineTrainer.Update failed: %s", err))
+	}
+	if t.model == nil {
+		corpus := newSparseCorpus(batch, nil)
+		m, err := trainCorpus(context.Background(), corpus, &t.param.TrainingParameter, nil)
+		if err != nil {
+			return errors.New(fmt.Sprintf("OnlineTrainer.Update failed: %s", err))
+		}
+		t.init(m, corpus.total)
+		return nil
+	}
+
+	m := t.model
+	newWords := t.addWords(batch.Vocabulary())
+	corpus := newSparseCorpus(batch, m.wordIndex)
+
+	// The batch model holds P(z) and P(d|z) of the batch documents alone,
+	// and P(w|z) estimated from the statistics of all the batches.
+	numTopics := m.NumberOfTopics()
+	b := &Model{
+		topicProb:     append([]float64(nil), m.topicProb...),
+		docTopicProb:  newMatrix(numTopics, corpus.numDocs()),
+		wordTopicProb: cloneMatrix(m.wordTopicProb),
+	}
+	for z := range b.docTopicProb {
+		for d := range b.docTopicProb[z] {
+			b.docTopicProb[z][d] = 1 / float64(corpus.numDocs())
+		}
+	}
+	decay := t.param.Decay
+	stats := newEMStats(numTopics, corpus, t.param.Workers, t.param.LogSpace)
+	mStep := func(z int) {
+		n_z := stats.topic[z]
+		b.topicProb[z] = n_z / corpus.total
+		if n_z > 0 {
+			for d, n_d_z := range stats.docTopic[z] {
+				b.docTopicProb[z][d] = n_d_z / n_z
+			}
+		}
+		for w, n_w_z := range stats.wordTopic[z] {
+			b.wordTopicProb[z][w] = decay*t.wordTopic[z][w] + n_w_z
+		}
+		normalize(b.wordTopicProb[z])
+	}
+
+	likelihood := math.NaN()
+	iter := 0
+	for corpus.total > 0 && iter < t.param.BatchIterations {
+		prev_likelihood := likelihood
+		likelihood = b.emIterationWith(corpus, stats, mStep)
+		iter++
+		if math.Abs((likelihood-prev_likelihood)/prev_likelihood) < t.param.LikelihoodIncLimit {
+			break
+		}
+	}
+
+	for z := 0; z < numTopics; z++ {
+		t.topic[z] = decay*t.topic[z] + stats.topic[z]
+		for w, n := range stats.wordTopic[z] {
+			t.wordTopic[z][w] = decay*t.wordTopic[z][w] + n
+		}
+		for d := range t.docTopic[z] {
+			t.docTopic[z][d] *= decay
+		}
+		t.docTopic[z] = append(t.docTopic[z], stats.docTopic[z]...)
+	}
+	for _, docId := range corpus.docIds {
+		(*m).docIndex[docId] = len(m.docIds)
+		(*m).docIds = append(m.docIds, docId)
+	}
+	t.updateModel()
+	t.batches++
+	log.Printf("Online batch %d: %d documents, %d new words, likelihood %f after %d iterations.\n",
+		t.batches, corpus.numDocs(), newWords, likelihood, iter)
+	return nil
+}
+
+// checkBatch returns an error if the batch has no words, or if one of its
+// documents appears twice or is already in the model.
+func (t *OnlineTrainer) checkBatch(batch DocWordFreqRetriever) error {
+	seen := make(map[string]bool)
+	total := uint64(0)
+	for _, docId := range batch.CorpusIds() {
+		if seen[docId] {
+			return errors.New(fmt.Sprintf("document [%s] appears twice in the batch", docId))
+		}
+		seen[docId] = true
+		if t.model != nil {
+			if _, found := t.model.docIndex[docId]; found {
+				return errors.New(fmt.Sprintf("document [%s] is already in the model", docId))
+			}
+		}
+		forEachWordInDoc(batch, docId, func(word string, count uint64) {
+			total += count
+		})
+	}
+	if total == 0 {
+		return errors.New("the batch has no words")
+	}
+	return nil
+}
+
+// addWords adds the words not in the model to its vocabulary, with a
+// small random n(w,z) in each topic, and returns the number of new words.
+func (t *OnlineTrainer) addWords(words []string) int {
+	m := t.model
+	n := 0
+	for _, word := range words {
+		if _, found := m.wordIndex[word]; found {
+			continue
+		}
+		(*m).wordIndex[word] = len(m.vocab)
+		(*m).vocab = append(m.vocab, word)
+		for z := range t.wordTopic {
+			t.wordTopic[z] = append(t.wordTopic[z], t.rng.Float64()*NewWordPseudoCount)
+		}
+		n++
+	}
+	if n > 0 {
+		for z := range t.wordTopic {
+			(*m).wordTopicProb[z] = append([]float64(nil), t.wordTopic[z]...)
+			normalize(m.wordTopicProb[z])
+		}
+	}
+	return n
+}
+
+// updateModel sets the model parameters from the sufficient statistics.
+func (t *OnlineTrainer) updateModel() {
+	m := t.model
+	(*m).topicProb = append([]float64(nil), t.topic...)
+	normalize(m.topicProb)
+	for z := range t.topic {
+		(*m).wordTopicProb[z] = append([]float64(nil), t.wordTopic[z]...)
+		normalize(m.wordTopicProb[z])
+		(*m).docTopicProb[z] = append([]float64(nil), t.docTopic[z]...)
+		normalize(m.docTopicProb[z])
+	}
+}
+
+// Model returns a copy of the current model, or nil before the first
+// batch.
+func (t *OnlineTrainer) Model() *Model {
+	if t.model == nil {
+		return nil
+	}
+	m := t.model.clone()
+	(*m).vocab = append([]string(nil), t.model.vocab...)
+	(*m).docIds = append([]string(nil), t.model.docIds...)
+	m.buildIndex()
+	return m
+}
+
+// Batches returns the number of batches the model has been trained on,
+// counting the model an OnlineTrainer was created from as one.
+func (t *OnlineTrainer) Batches() int {
+	return t.batches
+}
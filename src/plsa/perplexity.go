@@ -0,0 +1,84 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Perplexity computes the perplexity of the given held-out documents
+// using the document completion method: the words of each document are
+// split at random into two halves, the topic mixture P(z|d) of the
+// document is estimated by folding in the first half, and the second half
+// is scored with P(w|d) = sum_z P(z|d)P(w|z). A word occurring n times
+// counts as n words, which may fall in different halves. The split uses a
+// fixed seed, so that the same model always gets the same perplexity.
+// Words that are not part of the model are ignored. A lower perplexity
+// means a better model of the held-out data.
+func Perplexity(model *Model, heldOut DocWordFreqRetriever) float64 {
+	return model.completionPerplexity(newSparseCorpus(heldOut, model.wordIndex))
+}
+
+// perplexitySeed is the seed of the random split of held-out documents.
+const perplexitySeed = 1
+
+// completionPerplexity computes the document completion perplexity of
+// the given corpus, whose word indices must be those of the model.
+func (m *Model) completionPerplexity(corpus *sparseCorpus) float64 {
+	numTopics := m.NumberOfTopics()
+	topicMixture := make([]float64, numTopics)
+	r := rand.New(rand.NewSource(perplexitySeed))
+	var fitWords []int32
+	var fitCounts, scoredCounts []float64
+	likelihood := float64(0)
+	scored := float64(0)
+	for d := 0; d < corpus.numDocs(); d++ {
+		begin, end := corpus.docStart[d], corpus.docStart[d+1]
+		length := float64(0)
+		for i := begin; i < end; i++ {
+			length += corpus.counts[i]
+		}
+		// Draw the first half of the words, used for fold-in, without
+		// replacement: each remaining word is drawn with probability
+		// (words left to draw) / (words remaining).
+		toDraw, remaining := math.Floor(length/2), length
+		fitWords, fitCounts, scoredCounts = fitWords[:0], fitCounts[:0], scoredCounts[:0]
+		for i := begin; i < end; i++ {
+			fit := float64(0)
+			for n := corpus.counts[i]; n > 0; n-- {
+				if r.Float64()*remaining < toDraw {
+					fit++
+					toDraw--
+				}
+				remaining--
+			}
+			if fit > 0 {
+				fitWords = append(fitWords, corpus.wordIds[i])
+				fitCounts = append(fitCounts, fit)
+			}
+			scoredCounts = append(scoredCounts, corpus.counts[i]-fit)
+		}
+		m.foldIn(fitWords, fitCounts, topicMixture, &DefaultInferenceParameter)
+
+		for i := begin; i < end; i++ {
+			n := scoredCounts[i-begin]
+			if n <= 0 {
+				continue
+			}
+			w := corpus.wordIds[i]
+			p := float64(0)
+			for z := 0; z < numTopics; z++ {
+				p += topicMixture[z] * m.wordTopicProb[z][w]
+			}
+			likelihood += n * math.Log(p)
+			scored += n
+		}
+	}
+	if scored <= 0 {
+		return math.Inf(1)
+	}
+	return math.Exp(-likelihood / scored)
+}
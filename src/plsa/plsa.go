@@ -17,9 +17,11 @@
 package plsa
 
 import (
+	"context"
+	"log"
 	"math"
 	"math/rand"
-	"log"
+	"time"
 )
 
 // DocWordFreqRetriever is the interface that wraps the basic
@@ -27,7 +29,7 @@ import (
 // training corpus for the use of model training.
 //
 // LoadFromFile loads the document-word frequencies from the given file,
-// and returns true on success, false otherwise.
+// and returns an error describing the failure, if any.
 //
 // CorpusIds retrieves the entire list of document ids in the training
 // corpus.
@@ -42,8 +44,13 @@ import (
 //
 // GetDocWordCount returns the number of occurrence of word in the document
 // indexed by the given docId.
+//
+// Retrievers should also implement DocWordIterator. Training, filtering
+// and writing a corpus that does not have to call DocWordCount for every
+// pair of document and word, which takes time proportional to the number
+// of documents times the vocabulary size.
 type DocWordFreqRetriever interface {
-	LoadFromFile(docWordFreqFile string) bool
+	LoadFromFile(docWordFreqFile string) error
 	CorpusIds() []string
 	CorpusSize() int
 	Vocabulary() []string
@@ -51,20 +58,45 @@ type DocWordFreqRetriever interface {
 	DocWordCount(docId, word string) uint64
 }
 
-// Model holds the PLSA model data.
+// Model holds the PLSA model data. Words and documents are referred to by
+// their index in vocab and docIds respectively.
 type Model struct {
-	topicProb     []float32            //topic probability, P(z)
-	docTopicProb  []map[string]float32 //document probability given topic, P(d|z)
-	wordTopicProb []map[string]float32 //word probability given topic, P(w|z)
+	topicProb     []float64         //topic probability, P(z)
+	docTopicProb  [][]float64       //document probability given topic, P(d|z), indexed by [z][d]
+	wordTopicProb [][]float64       //word probability given topic, P(w|z), indexed by [z][w]
+	vocab         []string          //words of the training corpus
+	docIds        []string          //document ids of the training corpus
+	wordIndex     map[string]int    //index of each word in vocab
+	docIndex      map[string]int    //index of each document in docIds
+	param         TrainingParameter //parameter used to train the model
+	restarts      *RestartSummary   //restarts the model was selected from, nil for a single run
+	stopReason    string            //reason training stopped, empty if unknown
 }
 
-// SaveToFile saves the PLSA model to the given file.
-func (model *Model) SaveToFile(filename string) {
+// buildIndex rebuilds the word and document indices from vocab and docIds.
+func (m *Model) buildIndex() {
+	(*m).wordIndex = vocabIndex(m.vocab)
+	(*m).docIndex = make(map[string]int, len(m.docIds))
+	for i, d := range m.docIds {
+		(*m).docIndex[d] = i
+	}
 }
 
-// LoadModelFromFile loads a PLSA model from the given path.
-func LoadModelFromFile(filename string) *Model {
-	return nil
+// clone returns a deep copy of the model parameters.
+func (m *Model) clone() *Model {
+	c := *m
+	c.topicProb = append([]float64(nil), m.topicProb...)
+	c.docTopicProb = cloneMatrix(m.docTopicProb)
+	c.wordTopicProb = cloneMatrix(m.wordTopicProb)
+	return &c
+}
+
+func cloneMatrix(a [][]float64) [][]float64 {
+	c := make([][]float64, len(a))
+	for i := range a {
+		c[i] = append([]float64(nil), a[i]...)
+	}
+	return c
 }
 
 // NumberOfTopics returns the number of topics in the given PLSA model.
@@ -72,184 +104,400 @@ func (model *Model) NumberOfTopics() int {
 	return len(model.topicProb)
 }
 
+// Parameter returns the parameter used to train the model. A zero Seed is
+// replaced by the time based seed actually used, so that training again
+// with the returned parameter gives the same model.
+func (model *Model) Parameter() TrainingParameter {
+	return model.param
+}
+
 // TopicProbability returns the probability of the given topic_id, if
 // topic_id greater than or equal to the return value of NumberOfTopics,
 // 0 will be returned to signify that the topic does not exist.
-func (model *Model) TopicProbability(topicId int) float32 {
+func (model *Model) TopicProbability(topicId int) float64 {
 	if topicId < len(model.topicProb) {
 		return model.topicProb[topicId]
-	} 
-	return float32(0)
+	}
+	return 0
 }
 
 // WordProbabilityGivenTopic returns the probability of the given word
 // generated from topic with the given topic_id, if either the given word or topic_id
 // is not in the model, 0 will be returned.
-func (model *Model) WordProbabilityGivenTopic(word string, topicId int) float32 {
-	if topicId < len(model.wordTopicProb) {
-		return model.wordTopicProb[topicId][word]
+func (model *Model) WordProbabilityGivenTopic(word string, topicId int) float64 {
+	if w, found := model.wordIndex[word]; found && topicId < len(model.wordTopicProb) {
+		return model.wordTopicProb[topicId][w]
 	}
-	return float32(0)
+	return 0
 }
 
 // DocProbabilityGivenTopic returns the probability of the given document
 // that is generated from topic with the given topic_id. 0 will be returned
 // if either the document with the given id does not exists in the model or
 // that the topicId is not in the model.
-func (model *Model) DocProbabilityGivenTopic(docId string, topicId int) float32 {
-	if topicId < len(model.docTopicProb) {
-		return model.docTopicProb[topicId][docId]
+func (model *Model) DocProbabilityGivenTopic(docId string, topicId int) float64 {
+	if d, found := model.docIndex[docId]; found && topicId < len(model.docTopicProb) {
+		return model.docTopicProb[topicId][d]
 	}
-	return float32(0)
+	return 0
 }
 
 // TrainingParameter holds the parameter for training a PLSA model.
+//
+// Setting BetaDecay to a value in (0, 1) together with HeldOut enables
+// tempered EM (TEM) as described in Hofmann's paper: the posterior in the
+// E-step is computed as P(z|d,w) proportional to [P(z)P(d|z)P(w|z)]^beta,
+// and whenever the held-out perplexity (see Perplexity) stops improving,
+// beta is multiplied by BetaDecay and training continues from the
+// parameters having the best held-out perplexity so far. Training stops
+// when lowering beta does not bring any further improvement, or when beta
+// would fall below MinBeta.
+// With HeldOut but without BetaDecay, training simply stops early when the
+// held-out perplexity stops improving.
+//
+// Training stops when Stopping says so. By default, it stops when the
+// relative change of the likelihood is below LikelihoodIncLimit, after
+// MaxIteration iterations, or when the held-out perplexity stops improving
+// as described above. Criteria such as HeldOutPatience decide on the
+// held-out perplexity only when beta cannot be lowered any further.
+//
+// Setting CheckpointFile together with CheckpointEvery or
+// CheckpointInterval periodically saves the state of training, from which
+// an interrupted run can be continued with ResumeTraining. Training with
+// Restarts cannot be checkpointed: TrainFromDataContext fails if
+// CheckpointFile is set, and TrainFromData trains without checkpoints.
 type TrainingParameter struct {
-	NumberOfTopics     int     // Number of topics in the PLSA model.
-	LikelihoodIncLimit float32 // Minimum likelihood increment reached in training before stopping.
-	MaxIteration       int     //Maximum number of steps in the EM training procedure.
+	NumberOfTopics     int                  // Number of topics in the PLSA model.
+	LikelihoodIncLimit float64              // Minimum likelihood increment reached in training before stopping.
+	MaxIteration       int                  //Maximum number of steps in the EM training procedure, 0 means no limit.
+	Workers            int                  // Number of goroutines the documents are sharded across, less than 2 means single-threaded.
+	Beta               float64              // Initial inverse temperature of the E-step, 0 means 1, i.e. plain EM.
+	BetaDecay          float64              // Factor in (0, 1) beta is multiplied by when held-out perplexity stops improving.
+	MinBeta            float64              // Smallest beta used in tempered EM.
+	HeldOut            DocWordFreqRetriever // Optional held-out corpus for early stopping, not saved with the model.
+	Seed               int64                // Seed of the random initialization, 0 means derived from the current time.
+	Restarts           int                  // Number of models trained from different seeds to keep the best of, less than 2 means one.
+	RestartWorkers     int                  // Number of restarts trained concurrently, less than 2 means one at a time.
+	Init               Initializer          // Initialization of the model parameters, RandomInit by default.
+	InitSampleSize     int                  // Number of documents clustered by KMeansInit, 0 means DefaultInitSampleSize.
+	LogSpace           bool                 // Compute the E-step posteriors from log probabilities, avoiding underflow.
+	Debug              bool                 // Panic if a distribution does not sum to 1 or the likelihood decreases.
+	CheckpointFile     string               // Optional file the training state is periodically saved to, not saved with the model.
+	CheckpointEvery    int                  // Number of iterations between checkpoints, 0 means no iteration based checkpoints.
+	CheckpointInterval time.Duration        // Time between checkpoints, 0 means no time based checkpoints.
+	Stopping           StoppingCriterion    // Criterion deciding when training stops, nil means the default; not saved with the model.
+	Regularization     *Regularization      // Optional priors and sparsity regularizers making the M-step a MAP estimation.
 }
 
 // TrainFromData trains a PLSA model from the given document word frequency
-// data using the given training parameter..
+// data using the given training parameter. The document word frequencies are
+// first converted into a sparse index based representation, so the cost of
+// each EM iteration is proportional to the number of non-zero document word
+// counts rather than to the number of documents times the vocabulary size.
+// CheckpointFile is ignored when training with Restarts.
 func TrainFromData(docWordFreq DocWordFreqRetriever, param *TrainingParameter) *Model {
-	var m Model
-	// EM algorithm for training PLSA model.
-	probZgivenDW := (&m).randomInit(docWordFreq, param)
+	if param.Restarts > 1 && param.CheckpointFile != "" {
+		log.Printf("Checkpoints are not written when training with restarts.\n")
+		noCheckpoint := *param
+		noCheckpoint.CheckpointFile = ""
+		param = &noCheckpoint
+	}
+	m, err := TrainFromDataContext(context.Background(), docWordFreq, param, nil)
+	if err != nil {
+		log.Printf("TrainFromData failed: %s\n", err)
+	}
+	return m
+}
+
+// TrainFromDataContext is TrainFromData stopping early when ctx is done,
+// in which case it returns the best model so far together with ctx.Err().
+// The model is nil if no EM iteration has been done. If progress is not
+// nil, it is called after every EM iteration; calls are never concurrent,
+// even when restarts are trained concurrently.
+func TrainFromDataContext(ctx context.Context, docWordFreq DocWordFreqRetriever, param *TrainingParameter,
+	progress func(ProgressEvent)) (*Model, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	corpus := newSparseCorpus(docWordFreq, nil)
+	log.Printf("Loaded corpus: %d documents, %d words, %d non-zero counts.\n",
+		corpus.numDocs(), len(corpus.vocab), corpus.numNonZeros())
+	return trainCorpus(ctx, corpus, param, progress)
+}
 
-	log.Printf("EM training begin: %v.\n", *param)
-	prev_likelihood := float32(0)
-	iter := 0
+// trainCorpus is TrainFromDataContext on the sparse representation of the
+// training corpus.
+func trainCorpus(ctx context.Context, corpus *sparseCorpus, param *TrainingParameter,
+	progress func(ProgressEvent)) (*Model, error) {
+	var heldOut *sparseCorpus
+	if param.HeldOut != nil {
+		heldOut = newSparseCorpus(param.HeldOut, vocabIndex(corpus.vocab))
+		log.Printf("Loaded held-out corpus: %d documents, %d non-zero counts, %.0f unknown words.\n",
+			heldOut.numDocs(), heldOut.numNonZeros(), heldOut.dropped)
+	}
+
+	seed := param.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	progress = serialize(progress)
+	if param.Restarts > 1 {
+		return trainWithRestarts(ctx, corpus, heldOut, param, seed, progress)
+	}
+	return train(ctx, corpus, heldOut, param, seed, progress)
+}
+
+// vocabIndex returns the index of each word in vocab.
+func vocabIndex(vocab []string) map[string]int {
+	index := make(map[string]int, len(vocab))
+	for i, w := range vocab {
+		index[w] = i
+	}
+	return index
+}
+
+// train runs EM from a random initialization drawn with the given seed.
+func train(ctx context.Context, corpus, heldOut *sparseCorpus, param *TrainingParameter, seed int64,
+	progress func(ProgressEvent)) (*Model, error) {
+	log.Printf("EM training begin: %v, seed %d.\n", *param, seed)
+	return newTrainingState(corpus, param, seed).run(ctx, corpus, heldOut, param, progress)
+}
+
+// run performs EM iterations until training stops or ctx is done, writing a
+// checkpoint of the state before each iteration when param asks for one,
+// and returns the trained model. If ctx is done, the best model so far is
+// returned with ctx.Err(), and a checkpoint is written if param asks for
+// checkpoints, so that training can be resumed later.
+func (s *trainingState) run(ctx context.Context, corpus, heldOut *sparseCorpus, param *TrainingParameter,
+	progress func(ProgressEvent)) (*Model, error) {
+	m := s.model
+	stats := newEMStats(m.NumberOfTopics(), corpus, param.Workers, param.LogSpace)
+	criterion := param.stoppingCriterion()
+	var reg *regularizer
+	if param.Regularization != nil {
+		reg = newRegularizer(param.Regularization, corpus, m.NumberOfTopics())
+	}
+	prevObjective := math.NaN()
+	start := time.Now()
+	lastCheckpoint := start
 	for {
-		(&m).eStep(docWordFreq, probZgivenDW)
-		(&m).mStep(docWordFreq, probZgivenDW)
-		
-		likelihood := (&m).Likelihood(docWordFreq)
-		likelihood_improvement := math.Abs(float64((likelihood-prev_likelihood)/prev_likelihood))
-		
-		log.Printf("Iteration: %d, likelihood: %f, improvement: %f\n", 
-			iter, likelihood, likelihood_improvement)
-		
-		if likelihood_improvement < float64(param.LikelihoodIncLimit) {
-			break
+		if err := ctx.Err(); err != nil {
+			log.Printf("EM training cancelled at iteration %d: %s.\n", s.iteration, err)
+			if param.CheckpointFile != "" && s.iteration > 0 {
+				if err := s.saveCheckpoint(param.CheckpointFile, heldOut != nil); err != nil {
+					log.Printf("Checkpoint failed: %s\n", err)
+				}
+			}
+			if s.iteration == 0 {
+				return nil, err
+			}
+			return s.stop("cancelled: " + err.Error()), err
+		}
+		if s.checkpointDue(param, lastCheckpoint) {
+			if err := s.saveCheckpoint(param.CheckpointFile, heldOut != nil); err != nil {
+				log.Printf("Checkpoint failed, training continues: %s\n", err)
+			}
+			lastCheckpoint = time.Now()
+		}
+		stats.beta = s.beta
+		var likelihood, objective float64
+		if reg != nil {
+			penalty := m.regularization(reg)
+			likelihood = m.mapIteration(corpus, stats, reg)
+			objective = likelihood + penalty
+			log.Printf("Objective: %f, regularizer: %f\n", objective, penalty)
+			if param.Debug {
+				if !reg.monotone() {
+					prevObjective = math.NaN()
+				}
+				m.assertInvariants(objective, prevObjective, s.beta)
+			}
 		} else {
-			prev_likelihood = likelihood
+			likelihood = m.emIteration(corpus, stats)
+			objective = likelihood
+			if param.Debug {
+				m.assertInvariants(likelihood, s.prevLikelihood, s.beta)
+			}
+		}
+		prevObjective = objective
+		s.iteration++
+		s.likelihoods = append(s.likelihoods, likelihood)
+		status := TrainingStatus{
+			Iteration:       s.iteration,
+			Likelihood:      likelihood,
+			PrevLikelihood:  s.prevLikelihood,
+			Objective:       objective,
+			Elapsed:         time.Since(start),
+			ParameterChange: stats.parameterChange(),
 		}
-		if iter >= param.MaxIteration {
-			break
+		likelihood_improvement := math.Abs((likelihood - s.prevLikelihood) / s.prevLikelihood)
+		s.prevLikelihood = likelihood
+
+		log.Printf("Iteration: %d, likelihood: %f, improvement: %f\n",
+			s.iteration, likelihood, likelihood_improvement)
+		event := ProgressEvent{
+			Iteration:   s.iteration,
+			Likelihood:  likelihood,
+			Objective:   objective,
+			Improvement: likelihood_improvement,
+			Elapsed:     status.Elapsed,
+			TopicMass:   append([]float64(nil), m.topicProb...),
+			Beta:        s.beta,
+		}
+
+		if heldOut != nil {
+			perplexity := m.completionPerplexity(heldOut)
+			status.Perplexity, event.Perplexity = perplexity, perplexity
+			log.Printf("Held-out perplexity: %f (best %f), beta: %f\n", perplexity, s.bestPerplexity, s.beta)
+			if perplexity < s.bestPerplexity*(1-param.LikelihoodIncLimit) {
+				s.bestPerplexity = perplexity
+				s.best = m.clone()
+				s.improvedAtBeta = true
+				s.sinceImprovement = 0
+			} else if beta := s.beta * param.BetaDecay; s.improvedAtBeta &&
+				param.BetaDecay > 0 && param.BetaDecay < 1 && beta >= param.MinBeta {
+				log.Printf("Held-out perplexity stopped improving, lowering beta to %f.\n", beta)
+				s.beta = beta
+				*m = *s.best.clone()
+				s.improvedAtBeta = false
+				s.sinceImprovement = 0
+				// Training continues from other parameters, the change of
+				// the likelihood tells nothing about convergence.
+				s.prevLikelihood = math.NaN()
+				status.PrevLikelihood = math.NaN()
+				prevObjective = math.NaN()
+			} else {
+				s.sinceImprovement++
+			}
+			status.SinceImprovement = s.sinceImprovement
+		}
+		if progress != nil {
+			progress(event)
+		}
+
+		if stop, reason := criterion.Stop(&status); stop {
+			log.Printf("EM training end: %s.\n", reason)
+			return s.stop(reason), nil
 		}
 	}
-	log.Printf("EM training end.\n")
-	
-	return &m
 }
 
-type docIdWord struct {
-	docId string
-	word  string
+// stop records the reason training stopped in the resulting model and
+// returns it.
+func (s *trainingState) stop(reason string) *Model {
+	m := s.result()
+	(*m).stopReason = reason
+	return m
+}
+
+// result returns the model with the best held-out perplexity if there is
+// one, and the current model otherwise.
+func (s *trainingState) result() *Model {
+	if s.best != nil {
+		return s.best
+	}
+	return s.model
 }
 
-func (m *Model) randomInit(docWordFreq DocWordFreqRetriever, param *TrainingParameter) []map[docIdWord]float32 {
+// randomInit initializes P(d|z) and P(w|z) with random distributions drawn
+// from r, and P(z) with the uniform distribution.
+func (m *Model) randomInit(corpus *sparseCorpus, param *TrainingParameter, r *rand.Rand) {
 	numTopics := param.NumberOfTopics
-	numDocs := docWordFreq.CorpusSize()
-	numWords := docWordFreq.VocabularySize()
-	docIds := docWordFreq.CorpusIds()
-	words := docWordFreq.Vocabulary()
-	
-	(*m).topicProb = make([]float32, numTopics)
+	numDocs := corpus.numDocs()
+	numWords := len(corpus.vocab)
+
+	(*m).vocab = corpus.vocab
+	(*m).docIds = corpus.docIds
+	(*m).param = *param
+	(*m).param.HeldOut = nil
+	m.buildIndex()
+	(*m).topicProb = make([]float64, numTopics)
 	for z, _ := range (*m).topicProb {
-		(*m).topicProb[z] = float32(1) / float32(numTopics)
+		(*m).topicProb[z] = 1 / float64(numTopics)
 	}
-	(*m).docTopicProb = make([]map[string]float32, numTopics)
+	(*m).docTopicProb = make([][]float64, numTopics)
 	for z, _ := range (*m).docTopicProb {
-		(*m).docTopicProb[z] = make(map[string]float32, numDocs)
-		for _, d := range docIds {
-			(*m).docTopicProb[z][d] = rand.Float32()
+		(*m).docTopicProb[z] = make([]float64, numDocs)
+		for d, _ := range (*m).docTopicProb[z] {
+			(*m).docTopicProb[z][d] = r.Float64()
 		}
+		normalize((*m).docTopicProb[z])
 	}
-	(*m).wordTopicProb = make([]map[string]float32, numTopics)
+	(*m).wordTopicProb = make([][]float64, numTopics)
 	for z, _ := range (*m).wordTopicProb {
-		(*m).wordTopicProb[z] = make(map[string]float32, numWords)
-		for _, w := range words {
-			(*m).wordTopicProb[z][w] = rand.Float32()
+		(*m).wordTopicProb[z] = make([]float64, numWords)
+		for w, _ := range (*m).wordTopicProb[z] {
+			(*m).wordTopicProb[z][w] = r.Float64()
 		}
+		normalize((*m).wordTopicProb[z])
 	}
+}
 
-	probZgivenDW := make([]map[docIdWord]float32, numTopics)
-	for i, _ := range probZgivenDW {
-		probZgivenDW[i] = make(map[docIdWord]float32, numDocs*numWords)
-	}
-	return probZgivenDW
-}
-
-func (m *Model) eStep(docWordFreq DocWordFreqRetriever, probZgivenDW[]map[docIdWord]float32) {
-	docIds := docWordFreq.CorpusIds()
-	words := docWordFreq.Vocabulary()
-	numTopics := m.NumberOfTopics()
-	
-	norm_constant := float32(0)
-	for iter := 0; iter < 2; iter++ {
-		for z := 0; z < numTopics; z++ {
-			for _, w := range words {
-				for _, d := range docIds {
-					if iter < 1 {
-						p := m.TopicProbability(z) * m.DocProbabilityGivenTopic(d, z) * m.WordProbabilityGivenTopic(w, z)
-						probZgivenDW[z][docIdWord{d, w}] = p
-						norm_constant += p
-					} else {
-						probZgivenDW[z][docIdWord{d, w}] /= norm_constant
-					}
-				}
-			}
-		}
+// assertInvariants panics if the model parameters are not proper
+// distributions, or if the likelihood decreased since the previous
+// iteration. The likelihood, or the regularized objective in MAP
+// estimation, is only compared in plain EM, which is guaranteed not to
+// decrease it, and not when prev_likelihood is NaN, as right after a
+// restart from the best parameters in tempered EM.
+func (m *Model) assertInvariants(likelihood, prev_likelihood, beta float64) {
+	if err := m.checkInvariants(); err != nil {
+		log.Panicf("EM invariant violated: %s", err)
+	}
+	if beta == 1 && !math.IsNaN(prev_likelihood) &&
+		likelihood < prev_likelihood-ProbabilityTolerance*math.Abs(prev_likelihood) {
+		log.Panicf("EM invariant violated: likelihood decreased from %f to %f", prev_likelihood, likelihood)
 	}
 }
 
-func (m *Model) mStep(docWordFreq DocWordFreqRetriever, probZgivenDW[]map[docIdWord]float32) {
-	docIds := docWordFreq.CorpusIds()
-	words := docWordFreq.Vocabulary()
-	numTopics := m.NumberOfTopics()
-	
-	for z := 0; z < numTopics; z++ {
-		for _, w := range words {
-			p_w_z := float32(0)
-			for _, d := range docIds {
-				p_w_z += float32(docWordFreq.DocWordCount(d, w)) * probZgivenDW[z][docIdWord{d, w}]
-			}
-			(*m).wordTopicProb[z][w] = p_w_z
-		}
-		p_z := float32(0)
-		for _, d := range words {
-			p_d_z := float32(0)
-			for _, w := range words {
-				p_d_z += float32(docWordFreq.DocWordCount(d, w)) * probZgivenDW[z][docIdWord{d, w}]
-			}
-			(*m).docTopicProb[z][d] = p_d_z
-			p_z += p_d_z
+// normalize scales p to sum to 1.
+func normalize(p []float64) {
+	total := float64(0)
+	for _, v := range p {
+		total += v
+	}
+	if total > 0 {
+		for i := range p {
+			p[i] /= total
 		}
-		(*m).topicProb[z] = p_z
 	}
 }
 
 // Likelihood computes the log likelihood of reconstruction of data from
-// docWordFreq using the current model.
-func (m *Model) Likelihood(docWordFreq DocWordFreqRetriever) float32 {
-	docIds := docWordFreq.CorpusIds()
-	words := docWordFreq.Vocabulary()
-	numTopics := m.NumberOfTopics()
+// docWordFreq using the current model. Words and documents of docWordFreq
+// that are not part of the model have zero probability.
+func (m *Model) Likelihood(docWordFreq DocWordFreqRetriever) float64 {
+	return m.corpusLikelihood(newSparseCorpus(docWordFreq, m.wordIndex))
+}
 
+// corpusLikelihood computes the log likelihood of the given corpus, whose
+// word indices must be those of the model.
+func (m *Model) corpusLikelihood(corpus *sparseCorpus) float64 {
 	likelihood := float64(0)
-	for _, d := range docIds {
-		for _, w := range words {
-			count := docWordFreq.DocWordCount(d, w)
-			if count > 0 {
-				p_d_w := float32(0)
-				for z := 0; z < numTopics; z++ {
-					p_d_w += m.WordProbabilityGivenTopic(w, z) * m.DocProbabilityGivenTopic(d, z)
-				}
-				likelihood += float64(count) * math.Log(float64(p_d_w))
+	if corpus.dropped > 0 {
+		likelihood = math.Inf(-1)
+	}
+	for i, docId := range corpus.docIds {
+		d, found := m.docIndex[docId]
+		start, end := corpus.docStart[i], corpus.docStart[i+1]
+		if !found {
+			if end > start {
+				likelihood = math.Inf(-1)
 			}
+			continue
 		}
+		for j := start; j < end; j++ {
+			likelihood += corpus.counts[j] *
+				math.Log(m.wordDocProb(int(corpus.wordIds[j]), d))
+		}
+	}
+	return likelihood
+}
+
+// wordDocProb computes P(d,w) = sum_z P(z)P(d|z)P(w|z).
+func (m *Model) wordDocProb(w, d int) float64 {
+	p := float64(0)
+	for z, pz := range m.topicProb {
+		p += pz * m.docTopicProb[z][d] * m.wordTopicProb[z][w]
 	}
-	return float32(likelihood)
+	return p
 }
@@ -0,0 +1,598 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+type docIdWord struct {
+	docId string
+	word  string
+}
+
+// testCorpus is an in-memory DocWordFreqRetriever used by the tests.
+type testCorpus struct {
+	docIds []string
+	vocab  []string
+	count  map[docIdWord]uint64
+}
+
+func newTestCorpus(docs map[string]map[string]uint64, docIds []string) *testCorpus {
+	c := &testCorpus{docIds: docIds, count: make(map[docIdWord]uint64)}
+	seen := make(map[string]bool)
+	for _, d := range docIds {
+		// Words are added in a fixed order so that seeded training is reproducible.
+		var words []string
+		for w := range docs[d] {
+			words = append(words, w)
+		}
+		sort.Strings(words)
+		for _, w := range words {
+			if !seen[w] {
+				seen[w] = true
+				c.vocab = append(c.vocab, w)
+			}
+			c.count[docIdWord{d, w}] = docs[d][w]
+		}
+	}
+	return c
+}
+
+func (c *testCorpus) LoadFromFile(string) error       { return nil }
+func (c *testCorpus) CorpusIds() []string             { return c.docIds }
+func (c *testCorpus) CorpusSize() int                 { return len(c.docIds) }
+func (c *testCorpus) Vocabulary() []string            { return c.vocab }
+func (c *testCorpus) VocabularySize() int             { return len(c.vocab) }
+func (c *testCorpus) DocWordCount(d, w string) uint64 { return c.count[docIdWord{d, w}] }
+
+// twoTopicCorpus returns a corpus with two groups of documents.
+func twoTopicCorpus() *testCorpus {
+	return newTestCorpus(map[string]map[string]uint64{
+		"d0": {"鲜花": 4, "玫瑰": 3, "百合": 2},
+		"d1": {"鲜花": 2, "玫瑰": 5, "快递": 1},
+		"d2": {"百合": 3, "鲜花": 3},
+		"d3": {"游戏": 4, "动画": 3, "漫画": 2},
+		"d4": {"游戏": 1, "动画": 5, "快递": 1},
+		"d5": {"漫画": 4, "游戏": 2},
+	}, []string{"d0", "d1", "d2", "d3", "d4", "d5"})
+}
+
+func sumsToOne(p []float64) bool {
+	total := float64(0)
+	for _, v := range p {
+		total += v
+	}
+	return math.Abs(total-1) < 1e-4
+}
+
+func TestTrainFromData(t *testing.T) {
+	corpus := twoTopicCorpus()
+	param := TrainingParameter{NumberOfTopics: 2, LikelihoodIncLimit: 0.00001, MaxIteration: 100}
+	m := TrainFromData(corpus, &param)
+	if m.NumberOfTopics() != 2 {
+		t.Fatalf("Expected 2 topics but got %d.", m.NumberOfTopics())
+	}
+	if !sumsToOne(m.topicProb) {
+		t.Errorf("P(z) does not sum to 1: %v.", m.topicProb)
+	}
+	for z := 0; z < m.NumberOfTopics(); z++ {
+		if !sumsToOne(m.wordTopicProb[z]) {
+			t.Errorf("P(w|z=%d) does not sum to 1: %v.", z, m.wordTopicProb[z])
+		}
+		if !sumsToOne(m.docTopicProb[z]) {
+			t.Errorf("P(d|z=%d) does not sum to 1: %v.", z, m.docTopicProb[z])
+		}
+	}
+	if l := m.Likelihood(corpus); math.IsInf(float64(l), 0) || math.IsNaN(float64(l)) {
+		t.Errorf("Expected finite likelihood on training corpus but got %f.", l)
+	}
+}
+
+func TestSeededTraining(t *testing.T) {
+	param := TrainingParameter{NumberOfTopics: 2, LikelihoodIncLimit: 0.00001, MaxIteration: 100, Seed: 42}
+	m1 := TrainFromData(twoTopicCorpus(), &param)
+	m2 := TrainFromData(twoTopicCorpus(), &param)
+	if !reflect.DeepEqual(m1.wordTopicProb, m2.wordTopicProb) || !reflect.DeepEqual(m1.docTopicProb, m2.docTopicProb) {
+		t.Errorf("Expected training with the same seed to give the same model.")
+	}
+	if m1.Parameter().Seed != 42 {
+		t.Errorf("Expected seed 42 to be recorded but got %d.", m1.Parameter().Seed)
+	}
+	param.Seed = 0
+	if m := TrainFromData(twoTopicCorpus(), &param); m.Parameter().Seed == 0 {
+		t.Errorf("Expected the time based seed to be recorded in the model.")
+	}
+}
+
+func TestRestarts(t *testing.T) {
+	param := TrainingParameter{NumberOfTopics: 2, LikelihoodIncLimit: 0.00001, MaxIteration: 100,
+		Seed: 7, Restarts: 4, RestartWorkers: 4}
+	m := TrainFromData(twoTopicCorpus(), &param)
+	summary := m.RestartSummary()
+	if summary == nil || len(summary.Likelihoods) != 4 || summary.Seeds[3] != 10 {
+		t.Fatalf("Expected a summary of 4 restarts with seeds 7 to 10 but got %v.", summary)
+	}
+	for i, l := range summary.Likelihoods {
+		if l > summary.Likelihoods[summary.Best] {
+			t.Errorf("Restart %d has a higher likelihood than the selected restart %d.", i, summary.Best)
+		}
+	}
+	if min, max, _, _ := summary.LikelihoodSpread(); min > max || max != summary.Likelihoods[summary.Best] {
+		t.Errorf("Unexpected likelihood spread %f to %f.", min, max)
+	}
+	if m.Parameter().Seed != 7 {
+		t.Errorf("Expected the base seed 7 to be recorded but got %d.", m.Parameter().Seed)
+	}
+	param.RestartWorkers = 1
+	if sequential := TrainFromData(twoTopicCorpus(), &param); !reflect.DeepEqual(sequential.wordTopicProb, m.wordTopicProb) {
+		t.Errorf("Expected sequential and parallel restarts to select the same model.")
+	}
+	param.CheckpointFile, param.CheckpointEvery = "plsa_restarts_test.ckpt", 1
+	if m, err := TrainFromDataContext(context.Background(), twoTopicCorpus(), &param, nil); m != nil || err == nil {
+		t.Errorf("Expected restarts with a checkpoint file to be rejected.")
+	}
+	if m := TrainFromData(twoTopicCorpus(), &param); m == nil || m.RestartSummary() == nil {
+		t.Errorf("Expected TrainFromData to train restarts without checkpoints.")
+	}
+	if _, err := os.Stat(param.CheckpointFile); err == nil {
+		os.Remove(param.CheckpointFile)
+		t.Errorf("Expected no checkpoint to be written when training with restarts.")
+	}
+}
+
+func TestInitializers(t *testing.T) {
+	corpus := newSparseCorpus(twoTopicCorpus(), nil)
+	for _, init := range []Initializer{RandomInit, KMeansInit, RandomDocInit, NMFInit} {
+		param := TrainingParameter{NumberOfTopics: 2, Init: init}
+		var m Model
+		m.initialize(corpus, &param, rand.New(rand.NewSource(3)))
+		if !sumsToOne(m.topicProb) {
+			t.Errorf("%s: P(z) does not sum to 1: %v.", init, m.topicProb)
+		}
+		for z := 0; z < 2; z++ {
+			if !sumsToOne(m.wordTopicProb[z]) || !sumsToOne(m.docTopicProb[z]) {
+				t.Errorf("%s: P(w|z=%d) or P(d|z=%d) does not sum to 1.", init, z, z)
+			}
+			for _, p := range m.wordTopicProb[z] {
+				if p <= 0 {
+					t.Errorf("%s: expected P(w|z=%d) to be positive but got %v.", init, z, m.wordTopicProb[z])
+					break
+				}
+			}
+		}
+		if name, err := ParseInitializer(init.String()); err != nil || name != init {
+			t.Errorf("ParseInitializer(%s) failed: %v.", init, err)
+		}
+	}
+}
+
+func TestParallelEMIteration(t *testing.T) {
+	corpus := newSparseCorpus(twoTopicCorpus(), nil)
+	param := TrainingParameter{NumberOfTopics: 3}
+	var single Model
+	single.randomInit(corpus, &param, rand.New(rand.NewSource(1)))
+	parallel := single.clone()
+	singleStats := newEMStats(3, corpus, 1, false)
+	parallelStats := newEMStats(3, corpus, 4, false)
+	if len(parallelStats.shards) < 2 {
+		t.Fatalf("Expected the documents to be split into several shards but got %d.", len(parallelStats.shards))
+	}
+	for iter := 0; iter < 10; iter++ {
+		l1 := single.emIteration(corpus, singleStats)
+		l2 := parallel.emIteration(corpus, parallelStats)
+		if math.Abs(l1-l2) > 1e-3 {
+			t.Fatalf("Iteration %d: single-threaded likelihood %f, parallel %f.", iter, l1, l2)
+		}
+	}
+	for z := 0; z < 3; z++ {
+		if math.Abs(single.topicProb[z]-parallel.topicProb[z]) > 1e-4 {
+			t.Errorf("P(z=%d): single-threaded %f, parallel %f.", z, single.topicProb[z], parallel.topicProb[z])
+		}
+		for w := range single.wordTopicProb[z] {
+			if math.Abs(single.wordTopicProb[z][w]-parallel.wordTopicProb[z][w]) > 1e-4 {
+				t.Errorf("P(w=%d|z=%d): single-threaded %f, parallel %f.", w, z,
+					single.wordTopicProb[z][w], parallel.wordTopicProb[z][w])
+			}
+		}
+	}
+}
+
+func TestLogSpaceEM(t *testing.T) {
+	corpus := newSparseCorpus(twoTopicCorpus(), nil)
+	param := TrainingParameter{NumberOfTopics: 3}
+	var linear Model
+	linear.randomInit(corpus, &param, rand.New(rand.NewSource(1)))
+	logSpace := linear.clone()
+	linearStats := newEMStats(3, corpus, 1, false)
+	logStats := newEMStats(3, corpus, 1, true)
+	prev := math.Inf(-1)
+	for iter := 0; iter < 20; iter++ {
+		l1 := linear.emIteration(corpus, linearStats)
+		l2 := logSpace.emIteration(corpus, logStats)
+		if math.Abs(l1-l2) > 1e-9*math.Abs(l1) {
+			t.Fatalf("Iteration %d: linear likelihood %f, log-space %f.", iter, l1, l2)
+		}
+		if l2 < prev-1e-9 {
+			t.Errorf("Iteration %d: likelihood decreased from %f to %f.", iter, prev, l2)
+		}
+		prev = l2
+		if err := logSpace.checkInvariants(); err != nil {
+			t.Fatalf("Iteration %d: %s.", iter, err)
+		}
+	}
+
+	logSpace.wordTopicProb[0][0] += 0.5
+	if logSpace.checkInvariants() == nil {
+		t.Errorf("Expected an unnormalized P(w|z) to be detected.")
+	}
+
+	// Training in debug mode panics if an invariant is violated.
+	trainParam := TrainingParameter{NumberOfTopics: 2, LikelihoodIncLimit: 0.00001, MaxIteration: 100,
+		Seed: 3, LogSpace: true, Debug: true, Beta: 0.8}
+	m := TrainFromData(twoTopicCorpus(), &trainParam)
+	if !m.Parameter().LogSpace || !m.Parameter().Debug {
+		t.Errorf("Expected LogSpace and Debug to be recorded in the model.")
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	testFile := "plsa_checkpoint_test.txt"
+	defer func() {
+		os.Remove(testFile)
+	}()
+	param := TrainingParameter{NumberOfTopics: 2, LikelihoodIncLimit: 0.00001, MaxIteration: 100,
+		Seed: 5, Workers: 2, CheckpointFile: testFile, CheckpointEvery: 3}
+	m1 := TrainFromData(twoTopicCorpus(), &param)
+	m2, err := ResumeTraining(testFile, twoTopicCorpus())
+	if err != nil {
+		t.Fatalf("ResumeTraining(%s) failed: %s", testFile, err)
+	}
+	if !reflect.DeepEqual(m1.wordTopicProb, m2.wordTopicProb) || !reflect.DeepEqual(m1.docTopicProb, m2.docTopicProb) ||
+		!reflect.DeepEqual(m1.topicProb, m2.topicProb) {
+		t.Errorf("Expected the resumed run to give the same model as the uninterrupted one.")
+	}
+	if m2.Parameter().Seed != 5 || m2.Parameter().CheckpointEvery != 3 {
+		t.Errorf("Expected the training parameter to be restored but got %v.", m2.Parameter())
+	}
+
+	if _, err := ResumeTraining(testFile, newTestCorpus(map[string]map[string]uint64{"d0": {"a": 1}}, []string{"d0"})); err == nil {
+		t.Errorf("Expected ResumeTraining to fail on a different corpus.")
+	}
+	if _, err := ResumeTraining(testFile+".missing", twoTopicCorpus()); err == nil {
+		t.Errorf("Expected ResumeTraining to fail on a missing checkpoint.")
+	}
+}
+
+func TestTrainFromDataContext(t *testing.T) {
+	param := TrainingParameter{NumberOfTopics: 2, LikelihoodIncLimit: 0.00001, MaxIteration: 100, Seed: 11}
+	ctx, cancel := context.WithCancel(context.Background())
+	var events []ProgressEvent
+	m, err := TrainFromDataContext(ctx, twoTopicCorpus(), &param, func(e ProgressEvent) {
+		events = append(events, e)
+		if len(events) == 3 {
+			cancel()
+		}
+	})
+	if err != context.Canceled || m == nil {
+		t.Fatalf("Expected the model so far and context.Canceled but got %v, %v.", m, err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected training to stop after 3 iterations but got %d.", len(events))
+	}
+	for i, e := range events {
+		if e.Iteration != i+1 || !sumsToOne(e.TopicMass) || e.Elapsed <= 0 {
+			t.Errorf("Unexpected progress event %v.", e)
+		}
+	}
+	if _, err := TrainFromDataContext(ctx, twoTopicCorpus(), &param, nil); err != context.Canceled {
+		t.Errorf("Expected training with a cancelled context to fail but got %v.", err)
+	}
+
+	param.Restarts, param.RestartWorkers = 3, 3
+	restarts := make(map[int]bool)
+	m, err = TrainFromDataContext(context.Background(), twoTopicCorpus(), &param, func(e ProgressEvent) {
+		restarts[e.Restart] = true
+	})
+	if err != nil || m == nil || len(restarts) != 3 {
+		t.Errorf("Expected progress of 3 restarts but got %v, %v.", restarts, err)
+	}
+}
+
+func TestStoppingCriteria(t *testing.T) {
+	iterations := func(param *TrainingParameter) (int, string) {
+		n := 0
+		m, _ := TrainFromDataContext(context.Background(), twoTopicCorpus(), param, func(ProgressEvent) { n++ })
+		return n, m.StopReason()
+	}
+	param := TrainingParameter{NumberOfTopics: 2, LikelihoodIncLimit: 0.00001, MaxIteration: 2, Seed: 13}
+	if n, reason := iterations(&param); n != 2 || reason != "reached 2 iterations" {
+		t.Errorf("Expected MaxIteration to stop training after 2 iterations but got %d: %s.", n, reason)
+	}
+	param.Stopping = All(MaxIterations(2), MaxIterations(4))
+	if n, _ := iterations(&param); n != 4 {
+		t.Errorf("Expected All to stop training after 4 iterations but got %d.", n)
+	}
+	param.Stopping = Any(MaxIterations(5), MaxIterations(3))
+	if n, _ := iterations(&param); n != 3 {
+		t.Errorf("Expected Any to stop training after 3 iterations but got %d.", n)
+	}
+	param.Stopping = ParameterChange(1e-6)
+	if _, reason := iterations(&param); !strings.HasPrefix(reason, "parameter change") {
+		t.Errorf("Expected training to stop on the parameter change but got [%s].", reason)
+	}
+
+	first := TrainingStatus{Iteration: 1, Likelihood: -10, PrevLikelihood: math.NaN()}
+	for _, c := range []StoppingCriterion{RelativeLikelihoodChange(0.1), AbsoluteLikelihoodChange(1)} {
+		if stop, reason := c.Stop(&first); stop {
+			t.Errorf("Expected no likelihood change on the first iteration but got [%s].", reason)
+		}
+	}
+	status := TrainingStatus{Iteration: 2, Likelihood: -10, PrevLikelihood: -10.5, Elapsed: time.Minute, SinceImprovement: 3}
+	cases := []struct {
+		criterion StoppingCriterion
+		stop      bool
+	}{
+		{AbsoluteLikelihoodChange(1), true},
+		{AbsoluteLikelihoodChange(0.1), false},
+		{TimeBudget(time.Second), true},
+		{TimeBudget(time.Hour), false},
+		{HeldOutPatience(3), true},
+		{HeldOutPatience(4), false},
+		{All(), false},
+	}
+	for i, c := range cases {
+		if stop, reason := c.criterion.Stop(&status); stop != c.stop {
+			t.Errorf("Case %d: expected %t but got %t: %s.", i, c.stop, stop, reason)
+		}
+	}
+}
+
+func TestOnlineTrainer(t *testing.T) {
+	first := newTestCorpus(map[string]map[string]uint64{
+		"d0": {"鲜花": 3, "玫瑰": 2, "百合": 1},
+		"d1": {"鲜花": 2, "玫瑰": 5, "快递": 1},
+		"d3": {"游戏": 4, "动画": 3},
+	}, []string{"d0", "d1", "d3"})
+	second := newTestCorpus(map[string]map[string]uint64{
+		"d2": {"百合": 3, "鲜花": 3},
+		"d4": {"游戏": 1, "动画": 5, "快递": 1, "漫画": 2},
+		"d5": {"漫画": 4, "游戏": 2},
+	}, []string{"d2", "d4", "d5"})
+	param := OnlineParameter{
+		TrainingParameter: TrainingParameter{NumberOfTopics: 2, LikelihoodIncLimit: 0.00001, MaxIteration: 100, Seed: 17},
+		Decay:             0.9,
+	}
+	trainer := NewOnlineTrainer(&param)
+	if trainer.Model() != nil {
+		t.Errorf("Expected no model before the first batch.")
+	}
+	for _, batch := range []DocWordFreqRetriever{first, second} {
+		if err := trainer.Update(batch); err != nil {
+			t.Fatalf("OnlineTrainer.Update failed: %s", err)
+		}
+	}
+	m := trainer.Model()
+	if trainer.Batches() != 2 || len(m.vocab) != 7 || len(m.docIds) != 6 {
+		t.Fatalf("Expected 2 batches, 7 words and 6 documents but got %d, %d and %d.",
+			trainer.Batches(), len(m.vocab), len(m.docIds))
+	}
+	if err := m.checkInvariants(); err != nil {
+		t.Errorf("Online model is not normalized: %s.", err)
+	}
+	if m.WordProbabilityGivenTopic("漫画", 0)+m.WordProbabilityGivenTopic("漫画", 1) <= 0 {
+		t.Errorf("Expected the new word 漫画 to be assigned to the topics.")
+	}
+	if l := m.Likelihood(twoTopicCorpus()); math.IsInf(l, 0) || math.IsNaN(l) {
+		t.Errorf("Expected a finite likelihood of the whole corpus but got %f.", l)
+	}
+	if err := trainer.Update(second); err == nil {
+		t.Errorf("Expected adding documents already in the model to fail.")
+	}
+
+	resumed := NewOnlineTrainerFromModel(m, 40, &param)
+	third := newTestCorpus(map[string]map[string]uint64{"d6": {"玫瑰": 2, "花店": 3}}, []string{"d6"})
+	if err := resumed.Update(third); err != nil {
+		t.Fatalf("OnlineTrainer.Update failed: %s", err)
+	}
+	if err := resumed.Model().checkInvariants(); err != nil || resumed.Model().NumberOfTopics() != 2 {
+		t.Errorf("Unexpected resumed online model: %v.", err)
+	}
+	numWords := len(resumed.Model().vocab)
+	invalid := map[string]*testCorpus{
+		"empty":      newTestCorpus(map[string]map[string]uint64{}, nil),
+		"no words":   newTestCorpus(map[string]map[string]uint64{"d7": {"花店": 0}}, []string{"d7"}),
+		"duplicated": newTestCorpus(map[string]map[string]uint64{"d7": {"新词": 1}}, []string{"d7", "d7"}),
+	}
+	for name, batch := range invalid {
+		if err := resumed.Update(batch); err == nil {
+			t.Errorf("Expected %s batch to be rejected.", name)
+		}
+	}
+	if len(resumed.Model().vocab) != numWords {
+		t.Errorf("Expected rejected batches not to change the vocabulary.")
+	}
+	if len(m.vocab) != 7 {
+		t.Errorf("Expected the model returned earlier to be unaffected by later batches.")
+	}
+}
+
+func TestRegularization(t *testing.T) {
+	zeros := func(m *Model) int {
+		n := 0
+		for z := range m.wordTopicProb {
+			for _, p := range m.wordTopicProb[z] {
+				if p == 0 {
+					n++
+				}
+			}
+		}
+		return n
+	}
+	param := TrainingParameter{NumberOfTopics: 2, LikelihoodIncLimit: 0.00001, MaxIteration: 100, Seed: 19, Debug: true,
+		Regularization: &Regularization{WordPrior: 1, TopicPrior: 0.5, TopicPriors: []float64{0.5}}}
+	var events []ProgressEvent
+	m, _ := TrainFromDataContext(context.Background(), twoTopicCorpus(), &param, func(e ProgressEvent) {
+		events = append(events, e)
+	})
+	if err := m.checkInvariants(); err != nil {
+		t.Errorf("MAP model is not normalized: %s.", err)
+	}
+	if zeros(m) != 0 {
+		t.Errorf("Expected the prior to smooth every P(w|z) but got %d zeros.", zeros(m))
+	}
+	last := events[len(events)-1]
+	if last.Objective >= last.Likelihood {
+		t.Errorf("Expected the prior to lower the objective %f below the likelihood %f.", last.Objective, last.Likelihood)
+	}
+
+	param.Regularization = &Regularization{WordSparsity: []float64{1, 1}, DocSparsity: []float64{0.5, 0.5}}
+	m = TrainFromData(twoTopicCorpus(), &param)
+	if err := m.checkInvariants(); err != nil {
+		t.Errorf("Sparse model is not normalized: %s.", err)
+	}
+	if zeros(m) == 0 {
+		t.Errorf("Expected the sparsity regularizer to zero some P(w|z).")
+	}
+
+	param.Regularization = &Regularization{TopicPrior: 0.5, DocSparsity: []float64{0, 50}}
+	m = TrainFromData(twoTopicCorpus(), &param)
+	if m.TopicProbability(1) != 0 {
+		t.Errorf("Expected the sparsity regularizer to remove topic 1 but got P(z=1) = %g.", m.TopicProbability(1))
+	}
+	for z := range m.wordTopicProb {
+		if !sumsToOne(m.wordTopicProb[z]) {
+			t.Errorf("P(w|z=%d) does not sum to 1 after topic 1 was removed: %v.", z, m.wordTopicProb[z])
+		}
+	}
+}
+
+func TestInfer(t *testing.T) {
+	m := testModel()
+	mixture := m.Infer(map[string]uint64{"游戏": 10, "快递": 1, "未知": 5}, nil)
+	if !sumsToOne(mixture) {
+		t.Errorf("Inferred P(z|d) does not sum to 1: %v.", mixture)
+	}
+	if mixture[0] <= mixture[1] {
+		t.Errorf("Expected topic 0 to dominate the inferred mixture but got %v.", mixture)
+	}
+	partial := m.Infer(map[string]uint64{"游戏": 10, "快递": 1}, &InferenceParameter{LikelihoodIncLimit: 0.0001})
+	if !reflect.DeepEqual(partial, mixture) {
+		t.Errorf("Expected a parameter without MaxIteration to use the default but got %v.", partial)
+	}
+	mixture = m.Infer(map[string]uint64{"未知": 5}, nil)
+	for z, p := range mixture {
+		if p != m.TopicProbability(z) {
+			t.Errorf("Expected P(z) for document without known words but got %v.", mixture)
+			break
+		}
+	}
+}
+
+func TestTemperedEMWithHeldOut(t *testing.T) {
+	heldOut := newTestCorpus(map[string]map[string]uint64{
+		"h0": {"鲜花": 2, "玫瑰": 2, "未知": 1},
+		"h1": {"游戏": 3, "漫画": 1},
+	}, []string{"h0", "h1"})
+	param := TrainingParameter{NumberOfTopics: 2, LikelihoodIncLimit: 0.00001, MaxIteration: 100,
+		BetaDecay: 0.9, MinBeta: 0.5, HeldOut: heldOut}
+	m := TrainFromData(twoTopicCorpus(), &param)
+	if m.param.HeldOut != nil {
+		t.Errorf("The held-out corpus should not be kept in the model.")
+	}
+	if !sumsToOne(m.topicProb) {
+		t.Errorf("P(z) does not sum to 1: %v.", m.topicProb)
+	}
+	perplexity := Perplexity(m, heldOut)
+	if math.IsInf(perplexity, 0) || math.IsNaN(perplexity) || perplexity < 1 {
+		t.Errorf("Expected a finite held-out perplexity but got %f.", perplexity)
+	}
+}
+
+func TestPerplexity(t *testing.T) {
+	m := &Model{
+		topicProb:     []float64{1},
+		docTopicProb:  [][]float64{{1}},
+		wordTopicProb: [][]float64{{0.8, 0.2}},
+		vocab:         []string{"鲜花", "玫瑰"},
+		docIds:        []string{"d0"},
+	}
+	m.buildIndex()
+	heldOut := newTestCorpus(map[string]map[string]uint64{"h0": {"鲜花": 1000, "玫瑰": 1000}}, []string{"h0"})
+	// Half of the scored words are expected to be 鲜花 and half 玫瑰,
+	// giving the perplexity 1 / sqrt(0.8 * 0.2) = 2.5.
+	perplexity := Perplexity(m, heldOut)
+	if math.Abs(perplexity-2.5) > 0.1 {
+		t.Errorf("Expected a perplexity close to 2.5 but got %f.", perplexity)
+	}
+	if again := Perplexity(m, heldOut); again != perplexity {
+		t.Errorf("Expected the same perplexity %f on every call but got %f.", perplexity, again)
+	}
+}
+
+func TestVocabularyFilter(t *testing.T) {
+	filter := NewVocabularyFilter(twoTopicCorpus())
+	filter.MinCount = 6
+	filter.MaxDocRatio = 0.4
+	filter.TopK = 2
+	filter.Blocklist = []*regexp.Regexp{regexp.MustCompile("^快")}
+	filter.Apply()
+	if v := filter.Vocabulary(); len(v) != 2 || v[0] != "玫瑰" || v[1] != "动画" {
+		t.Errorf("Expected vocabulary [玫瑰 动画] but got %v.", v)
+	}
+	if filter.DocWordCount("d1", "玫瑰") != 5 || filter.DocWordCount("d0", "鲜花") != 0 {
+		t.Errorf("Unexpected counts in the filtered corpus.")
+	}
+	words := 0
+	filter.ForEachWordInDoc("d4", func(word string, count uint64) {
+		if word != "动画" || count != 5 {
+			t.Errorf("Unexpected word %s (%d) in d4.", word, count)
+		}
+		words++
+	})
+	if words != 1 {
+		t.Errorf("Expected 1 word in d4 but got %d.", words)
+	}
+	expected := map[string]RemovalReason{
+		"鲜花": AboveMaxDocRatio, "百合": BelowMinCount, "快递": Blocklisted,
+		"游戏": AboveMaxDocRatio, "漫画": BeyondTopK,
+	}
+	report := filter.Report()
+	if report.Kept != 2 || report.RemovedTokens != 29 || len(report.Removed) != len(expected) {
+		t.Errorf("Unexpected report: %s.", report)
+	}
+	for _, r := range report.Removed {
+		if expected[r.Word] != r.Reason {
+			t.Errorf("Expected %s to be removed as %s but got %s.", r.Word, expected[r.Word], r.Reason)
+		}
+	}
+
+	filter = NewVocabularyFilter(twoTopicCorpus())
+	filter.TopK = 1
+	filter.Ranking = ByTFIDF
+	filter.Apply()
+	if v := filter.Vocabulary(); len(v) != 1 || v[0] != "动画" {
+		t.Errorf("Expected [动画] to have the highest TF-IDF but got %v.", v)
+	}
+
+	corpus := twoTopicCorpus()
+	(*corpus).vocab = append([]string{"未用"}, corpus.vocab...)
+	filter = NewVocabularyFilter(corpus)
+	filter.TopK = 1
+	filter.Ranking = ByTFIDF
+	filter.Apply()
+	if v := filter.Vocabulary(); len(v) != 1 || v[0] != "动画" {
+		t.Errorf("Expected a word in no document not to be ranked first but got %v.", v)
+	}
+}
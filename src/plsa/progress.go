@@ -0,0 +1,38 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"sync"
+	"time"
+)
+
+// ProgressEvent describes the state of training after an EM iteration, as
+// passed to the progress callback of TrainFromDataContext.
+type ProgressEvent struct {
+	Restart     int           // Index of the restart, 0 without restarts.
+	Iteration   int           // Number of EM iterations done, starting from 1.
+	Likelihood  float64       // Log likelihood of the training corpus before the iteration's update.
+	Objective   float64       // Likelihood plus the regularizer of TrainingParameter.Regularization, if any.
+	Improvement float64       // Relative change of the likelihood since the previous iteration, NaN if there is none.
+	Elapsed     time.Duration // Time since the training run started.
+	TopicMass   []float64     // P(z) after the iteration.
+	Beta        float64       // Inverse temperature of the E-step.
+	Perplexity  float64       // Held-out perplexity after the iteration, 0 without held-out corpus.
+}
+
+// serialize returns a function calling progress under a lock, or nil if
+// progress is nil.
+func serialize(progress func(ProgressEvent)) func(ProgressEvent) {
+	if progress == nil {
+		return nil
+	}
+	var mu sync.Mutex
+	return func(e ProgressEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		progress(e)
+	}
+}
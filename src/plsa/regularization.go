@@ -0,0 +1,200 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"math"
+)
+
+// Regularization turns the M-step of PLSA training into maximum a
+// posteriori (MAP) estimation, in the additive regularization (ARTM) form
+// of Vorontsov and Potapenko:
+//
+//	P(w|z) proportional to max(n(w,z) + b(w) - s(z), 0)
+//	P(z|d) proportional to max(n(d,z) + a(z) - t(z), 0)
+//
+// where b(w) = WordPrior + WordPriors[w] and a(z) = TopicPrior +
+// TopicPriors[z] are the parameters minus 1 of Dirichlet priors on P(w|z)
+// and P(z|d), and s(z) = WordSparsity[z] and t(z) = DocSparsity[z] are
+// the coefficients of the regularizers making P(w|z) and P(z|d) of topic z
+// sparse. P(z) and P(d|z) are derived from P(z|d) and the document lengths.
+// A topic missing from the slices counts as 0, and so does a word missing
+// from WordPriors.
+//
+// The objective maximized is the log likelihood plus the regularizer
+//
+//	sum_z sum_w (b(w) - s(z)) log P(w|z) + sum_d sum_z (a(z) - t(z)) log P(z|d)
+//
+// in which zero probabilities are left out.
+type Regularization struct {
+	WordPrior    float64            // Symmetric Dirichlet prior on P(w|z), pseudo-count added to every n(w,z).
+	WordPriors   map[string]float64 // Asymmetric part of the prior on P(w|z), pseudo-count of each word.
+	TopicPrior   float64            // Symmetric Dirichlet prior on P(z|d), pseudo-count added to every n(d,z).
+	TopicPriors  []float64          // Asymmetric part of the prior on P(z|d), pseudo-count of each topic.
+	WordSparsity []float64          // Sparsity coefficient of P(w|z) of each topic, subtracted from n(w,z).
+	DocSparsity  []float64          // Sparsity coefficient of P(z|d) of each topic, subtracted from n(d,z).
+}
+
+// regularizer holds the pseudo-counts of a Regularization resolved for the
+// words, documents and topics of a training corpus.
+type regularizer struct {
+	word      []float64 // b(w)
+	sparsity  []float64 // s(z)
+	topic     []float64 // a(z) - t(z)
+	docLength []float64 // n(d)
+}
+
+func newRegularizer(r *Regularization, corpus *sparseCorpus, numTopics int) *regularizer {
+	reg := &regularizer{
+		word:      make([]float64, len(corpus.vocab)),
+		sparsity:  make([]float64, numTopics),
+		topic:     make([]float64, numTopics),
+		docLength: make([]float64, corpus.numDocs()),
+	}
+	for w, word := range corpus.vocab {
+		reg.word[w] = r.WordPrior + r.WordPriors[word]
+	}
+	for z := 0; z < numTopics; z++ {
+		reg.sparsity[z] = topicValue(r.WordSparsity, z)
+		reg.topic[z] = r.TopicPrior + topicValue(r.TopicPriors, z) - topicValue(r.DocSparsity, z)
+	}
+	for d := range reg.docLength {
+		for i := corpus.docStart[d]; i < corpus.docStart[d+1]; i++ {
+			reg.docLength[d] += corpus.counts[i]
+		}
+	}
+	return reg
+}
+
+func topicValue(values []float64, z int) float64 {
+	if z < len(values) {
+		return values[z]
+	}
+	return 0
+}
+
+// monotone tells whether EM is guaranteed not to decrease the objective,
+// which holds when no pseudo-count is negative.
+func (reg *regularizer) monotone() bool {
+	for _, c := range reg.word {
+		if c < 0 {
+			return false
+		}
+	}
+	for z, c := range reg.topic {
+		if c < 0 || reg.sparsity[z] > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// regularization computes the regularizer of the current model parameters.
+func (m *Model) regularization(reg *regularizer) float64 {
+	value := float64(0)
+	for z := range m.topicProb {
+		for w, p := range m.wordTopicProb[z] {
+			if c := reg.word[w] - reg.sparsity[z]; c != 0 && p > 0 {
+				value += c * math.Log(p)
+			}
+		}
+	}
+	for d := range m.docIds {
+		p_d := float64(0)
+		for z, pz := range m.topicProb {
+			p_d += pz * m.docTopicProb[z][d]
+		}
+		for z, pz := range m.topicProb {
+			if p := pz * m.docTopicProb[z][d]; reg.topic[z] != 0 && p > 0 {
+				value += reg.topic[z] * math.Log(p/p_d)
+			}
+		}
+	}
+	return value
+}
+
+// mapIteration is emIteration with the MAP M-step of the regularizer.
+func (m *Model) mapIteration(corpus *sparseCorpus, stats *emStats, reg *regularizer) float64 {
+	likelihood := m.emIterationWith(corpus, stats, func(z int) {
+		m.mapWordStep(stats, reg, z)
+	})
+	m.mapDocStep(corpus, stats, reg)
+	return likelihood
+}
+
+// mapWordStep re-estimates P(w|z) of topic z. If the regularizer makes
+// every probability 0, the maximum likelihood estimate is used instead,
+// and like in mStep, P(w|z) is kept when the topic has no expected count.
+func (m *Model) mapWordStep(stats *emStats, reg *regularizer, z int) {
+	p := (*m).wordTopicProb[z]
+	old := append([]float64(nil), p...)
+	total, n_z := float64(0), float64(0)
+	for w, n_w_z := range stats.wordTopic[z] {
+		p[w] = math.Max(n_w_z+reg.word[w]-reg.sparsity[z], 0)
+		total += p[w]
+		n_z += n_w_z
+	}
+	if total <= 0 && n_z > 0 {
+		copy(p, stats.wordTopic[z])
+	} else if total <= 0 {
+		copy(p, old)
+	}
+	normalize(p)
+	stats.change[z] = maxChange(old, p)
+}
+
+// mapDocStep re-estimates P(z|d) of every document, and from them P(z) and
+// P(d|z) as P(z)P(d|z) = P(d)P(z|d) with P(d) proportional to the length
+// of d. The regularized counts n(d)P(z|d) replace n(d,z) in stats.
+func (m *Model) mapDocStep(corpus *sparseCorpus, stats *emStats, reg *regularizer) {
+	workers := len(stats.shards)
+	parallelFor(workers, workers, func(i int) {
+		shard := stats.shards[i]
+		theta := shard.posterior
+		for d := shard.begin; d < shard.end; d++ {
+			total := float64(0)
+			for z := range theta {
+				theta[z] = math.Max(stats.docTopic[z][d]+reg.topic[z], 0)
+				total += theta[z]
+			}
+			if total <= 0 {
+				for z := range theta {
+					theta[z] = stats.docTopic[z][d]
+					total += theta[z]
+				}
+			}
+			for z := range theta {
+				stats.docTopic[z][d] = 0
+				if total > 0 {
+					stats.docTopic[z][d] = reg.docLength[d] * theta[z] / total
+				}
+			}
+		}
+	})
+	parallelFor(m.NumberOfTopics(), workers, func(z int) {
+		n_z := float64(0)
+		for _, n := range stats.docTopic[z] {
+			n_z += n
+		}
+		change := math.Abs(n_z/corpus.total - m.topicProb[z])
+		(*m).topicProb[z] = n_z / corpus.total
+		if n_z > 0 {
+			old := append([]float64(nil), m.docTopicProb[z]...)
+			for d, n := range stats.docTopic[z] {
+				(*m).docTopicProb[z][d] = n / n_z
+			}
+			change = math.Max(change, maxChange(old, m.docTopicProb[z]))
+		}
+		stats.change[z] = math.Max(stats.change[z], change)
+	})
+}
+
+func maxChange(a, b []float64) float64 {
+	change := float64(0)
+	for i := range a {
+		change = math.Max(change, math.Abs(a[i]-b[i]))
+	}
+	return change
+}
@@ -0,0 +1,130 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+)
+
+// RestartSummary describes the models trained from different random
+// initializations when TrainingParameter.Restarts is greater than 1. The
+// spread of the final likelihoods tells how much the result depends on
+// the initialization for the chosen number of topics.
+type RestartSummary struct {
+	Seeds        []int64   // Seed of each restart.
+	Likelihoods  []float64 // Final log likelihood of the training corpus of each restart.
+	Perplexities []float64 // Final held-out perplexity of each restart, nil without held-out corpus.
+	Best         int       // Index of the selected restart.
+}
+
+// LikelihoodSpread returns the minimum, maximum, mean and standard
+// deviation of the final training log likelihoods of the restarts.
+func (s *RestartSummary) LikelihoodSpread() (min, max, mean, stdev float64) {
+	return spread(s.Likelihoods)
+}
+
+func spread(values []float64) (min, max, mean, stdev float64) {
+	if len(values) == 0 {
+		return math.NaN(), math.NaN(), math.NaN(), math.NaN()
+	}
+	min, max = math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+		mean += v
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		stdev += (v - mean) * (v - mean)
+	}
+	stdev = math.Sqrt(stdev / float64(len(values)))
+	return
+}
+
+func (s *RestartSummary) String() string {
+	min, max, mean, stdev := s.LikelihoodSpread()
+	str := fmt.Sprintf("%d restarts, selected #%d (seed %d), likelihood min %f, max %f, mean %f, stdev %f",
+		len(s.Seeds), s.Best, s.Seeds[s.Best], min, max, mean, stdev)
+	if s.Perplexities != nil {
+		min, max, mean, stdev := spread(s.Perplexities)
+		str += fmt.Sprintf(", held-out perplexity min %f, max %f, mean %f, stdev %f", min, max, mean, stdev)
+	}
+	return str
+}
+
+// RestartSummary returns the summary of the restarts the model was
+// selected from, or nil if it was trained in a single run or loaded from a
+// file of model format version 1, which does not save it.
+func (model *Model) RestartSummary() *RestartSummary {
+	return model.restarts
+}
+
+// trainWithRestarts trains param.Restarts models with the seeds seed,
+// seed+1, ... and returns the one with the lowest held-out perplexity if
+// heldOut is not nil, and with the highest training likelihood otherwise.
+// The model records seed as its seed, so that training again with the same
+// parameter gives the same result. If ctx is done, the restarts not yet
+// started are skipped and the best of the others is returned with
+// ctx.Err(). Restarts cannot be checkpointed, so it is an error to set
+// param.CheckpointFile.
+func trainWithRestarts(ctx context.Context, corpus, heldOut *sparseCorpus, param *TrainingParameter, seed int64,
+	progress func(ProgressEvent)) (*Model, error) {
+	n := param.Restarts
+	if param.CheckpointFile != "" {
+		return nil, errors.New(fmt.Sprintf("checkpoint file %s given for training with %d restarts, "+
+			"which cannot be checkpointed", param.CheckpointFile, n))
+	}
+	models := make([]*Model, n)
+	parallelFor(n, param.RestartWorkers, func(i int) {
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Restart %d of %d, seed %d.\n", i+1, n, seed+int64(i))
+		var restartProgress func(ProgressEvent)
+		if progress != nil {
+			restartProgress = func(e ProgressEvent) {
+				e.Restart = i
+				progress(e)
+			}
+		}
+		models[i], _ = train(ctx, corpus, heldOut, param, seed+int64(i), restartProgress)
+	})
+
+	summary := &RestartSummary{}
+	var trained []*Model
+	for i, m := range models {
+		if m == nil {
+			continue
+		}
+		summary.Seeds = append(summary.Seeds, seed+int64(i))
+		summary.Likelihoods = append(summary.Likelihoods, m.corpusLikelihood(corpus))
+		if heldOut != nil {
+			summary.Perplexities = append(summary.Perplexities, m.completionPerplexity(heldOut))
+		}
+		trained = append(trained, m)
+	}
+	if len(trained) == 0 {
+		return nil, ctx.Err()
+	}
+	for i := 1; i < len(trained); i++ {
+		if heldOut != nil {
+			if summary.Perplexities[i] < summary.Perplexities[summary.Best] {
+				summary.Best = i
+			}
+		} else if summary.Likelihoods[i] > summary.Likelihoods[summary.Best] {
+			summary.Best = i
+		}
+	}
+	log.Printf("Restarts: %s.\n", summary)
+
+	best := trained[summary.Best]
+	(*best).param.Seed = seed
+	(*best).restarts = summary
+	return best, ctx.Err()
+}
@@ -0,0 +1,95 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"log"
+)
+
+// DocWordIterator is implemented by the DocWordFreqRetrievers that can
+// enumerate the words of a document that have a non-zero count, which
+// allows the training corpus to be loaded without probing every
+// document-word pair.
+//
+// ForEachWordInDoc calls processor with each word of the document indexed
+// by the given docId together with its number of occurrence.
+type DocWordIterator interface {
+	ForEachWordInDoc(docId string, processor func(word string, count uint64))
+}
+
+// sparseCorpus stores the non-zero document-word counts in the compressed
+// sparse row (CSR) layout: the words of the i-th document are
+// wordIds[docStart[i]:docStart[i+1]] with counts in the same range of counts.
+type sparseCorpus struct {
+	docIds   []string
+	vocab    []string
+	docStart []int
+	wordIds  []int32
+	counts   []float64
+	total    float64 // sum of all the counts
+	dropped  float64 // sum of the counts of words not in the vocabulary
+}
+
+// newSparseCorpus converts the given document word frequencies into a
+// sparseCorpus. If wordIndex is nil, the vocabulary of docWordFreq is used,
+// otherwise words are indexed by wordIndex and those not found in it are
+// dropped. If docWordFreq is not a DocWordIterator, every document-word
+// pair is probed with DocWordCount.
+func newSparseCorpus(docWordFreq DocWordFreqRetriever, wordIndex map[string]int) *sparseCorpus {
+	var c sparseCorpus
+	c.docIds = docWordFreq.CorpusIds()
+	if wordIndex == nil {
+		c.vocab = docWordFreq.Vocabulary()
+		wordIndex = make(map[string]int, len(c.vocab))
+		for i, w := range c.vocab {
+			wordIndex[w] = i
+		}
+	} else {
+		c.vocab = make([]string, len(wordIndex))
+		for w, i := range wordIndex {
+			c.vocab[i] = w
+		}
+	}
+
+	add := func(word string, count uint64) {
+		if count == 0 {
+			return
+		}
+		if w, found := wordIndex[word]; found {
+			c.wordIds = append(c.wordIds, int32(w))
+			c.counts = append(c.counts, float64(count))
+			c.total += float64(count)
+		} else {
+			c.dropped += float64(count)
+		}
+	}
+	iterator, isIterator := docWordFreq.(DocWordIterator)
+	words := docWordFreq.Vocabulary()
+	if !isIterator {
+		log.Printf("%T is not a DocWordIterator, the counts of all %d documents and %d words are probed.\n",
+			docWordFreq, len(c.docIds), len(words))
+	}
+	c.docStart = make([]int, 0, len(c.docIds)+1)
+	for _, d := range c.docIds {
+		c.docStart = append(c.docStart, len(c.wordIds))
+		if isIterator {
+			iterator.ForEachWordInDoc(d, add)
+		} else {
+			for _, w := range words {
+				add(w, docWordFreq.DocWordCount(d, w))
+			}
+		}
+	}
+	c.docStart = append(c.docStart, len(c.wordIds))
+	return &c
+}
+
+func (c *sparseCorpus) numDocs() int {
+	return len(c.docIds)
+}
+
+func (c *sparseCorpus) numNonZeros() int {
+	return len(c.wordIds)
+}
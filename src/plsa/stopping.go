@@ -0,0 +1,138 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// TrainingStatus is the state of training after an EM iteration that
+// stopping criteria decide on.
+type TrainingStatus struct {
+	Iteration        int           // Number of EM iterations done, starting from 1.
+	Likelihood       float64       // Log likelihood of the training corpus in the last iteration.
+	PrevLikelihood   float64       // Log likelihood in the previous iteration, NaN if there is none.
+	Objective        float64       // Log likelihood plus the regularizer of TrainingParameter.Regularization, if any.
+	Elapsed          time.Duration // Time since training started or was resumed.
+	Perplexity       float64       // Held-out perplexity after the last iteration, 0 without held-out corpus.
+	SinceImprovement int           // Number of iterations since the held-out perplexity last improved.
+	ParameterChange  float64       // Largest absolute change of P(z), P(w|z) or P(d|z) in the last iteration.
+}
+
+// StoppingCriterion decides when EM training stops.
+//
+// Stop is called after every EM iteration and returns true together with
+// a description of the reason to stop training.
+type StoppingCriterion interface {
+	Stop(status *TrainingStatus) (bool, string)
+}
+
+// StoppingFunc adapts a function to the StoppingCriterion interface.
+type StoppingFunc func(status *TrainingStatus) (bool, string)
+
+func (f StoppingFunc) Stop(status *TrainingStatus) (bool, string) {
+	return f(status)
+}
+
+// RelativeLikelihoodChange stops when the likelihood changes by less than
+// the given fraction of the previous likelihood.
+func RelativeLikelihoodChange(limit float64) StoppingCriterion {
+	return StoppingFunc(func(s *TrainingStatus) (bool, string) {
+		change := math.Abs((s.Likelihood - s.PrevLikelihood) / s.PrevLikelihood)
+		return change < limit, fmt.Sprintf("relative likelihood change %g below %g", change, limit)
+	})
+}
+
+// AbsoluteLikelihoodChange stops when the likelihood changes by less than
+// limit.
+func AbsoluteLikelihoodChange(limit float64) StoppingCriterion {
+	return StoppingFunc(func(s *TrainingStatus) (bool, string) {
+		change := math.Abs(s.Likelihood - s.PrevLikelihood)
+		return change < limit, fmt.Sprintf("likelihood change %g below %g", change, limit)
+	})
+}
+
+// MaxIterations stops after n iterations.
+func MaxIterations(n int) StoppingCriterion {
+	return StoppingFunc(func(s *TrainingStatus) (bool, string) {
+		return s.Iteration >= n, fmt.Sprintf("reached %d iterations", n)
+	})
+}
+
+// TimeBudget stops once training has run for the given duration.
+func TimeBudget(budget time.Duration) StoppingCriterion {
+	return StoppingFunc(func(s *TrainingStatus) (bool, string) {
+		return s.Elapsed >= budget, fmt.Sprintf("time budget of %s used", budget)
+	})
+}
+
+// HeldOutPatience stops when the held-out perplexity has not improved for
+// the given number of iterations. It never stops without held-out corpus.
+func HeldOutPatience(patience int) StoppingCriterion {
+	return StoppingFunc(func(s *TrainingStatus) (bool, string) {
+		return s.SinceImprovement >= patience,
+			fmt.Sprintf("held-out perplexity not improved for %d iterations", s.SinceImprovement)
+	})
+}
+
+// ParameterChange stops when no probability of the model changes by more
+// than limit in an iteration.
+func ParameterChange(limit float64) StoppingCriterion {
+	return StoppingFunc(func(s *TrainingStatus) (bool, string) {
+		return s.ParameterChange < limit, fmt.Sprintf("parameter change %g below %g", s.ParameterChange, limit)
+	})
+}
+
+// All stops when every one of the criteria stops.
+func All(criteria ...StoppingCriterion) StoppingCriterion {
+	return StoppingFunc(func(s *TrainingStatus) (bool, string) {
+		reasons := make([]string, len(criteria))
+		for i, c := range criteria {
+			stop, reason := c.Stop(s)
+			if !stop {
+				return false, ""
+			}
+			reasons[i] = reason
+		}
+		return len(criteria) > 0, strings.Join(reasons, " and ")
+	})
+}
+
+// Any stops when one of the criteria stops, giving the reason of the first
+// one that does.
+func Any(criteria ...StoppingCriterion) StoppingCriterion {
+	return StoppingFunc(func(s *TrainingStatus) (bool, string) {
+		for _, c := range criteria {
+			if stop, reason := c.Stop(s); stop {
+				return true, reason
+			}
+		}
+		return false, ""
+	})
+}
+
+// stoppingCriterion returns param.Stopping, or if it is nil, the default
+// criterion stopping when the relative likelihood change is below
+// LikelihoodIncLimit, after MaxIteration iterations if it is positive, and
+// as soon as the held-out perplexity stops improving.
+func (param *TrainingParameter) stoppingCriterion() StoppingCriterion {
+	if param.Stopping != nil {
+		return param.Stopping
+	}
+	criteria := []StoppingCriterion{RelativeLikelihoodChange(param.LikelihoodIncLimit), HeldOutPatience(1)}
+	if param.MaxIteration > 0 {
+		criteria = append(criteria, MaxIterations(param.MaxIteration))
+	}
+	return Any(criteria...)
+}
+
+// StopReason describes why training of the model stopped. It is empty for
+// models loaded from files of model format version 1, which do not save it.
+func (model *Model) StopReason() string {
+	return model.stopReason
+}
@@ -0,0 +1,103 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"bufio"
+	"charset"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type wordProb struct {
+	word int
+	prob float64
+}
+
+type byProb []wordProb
+
+func (s byProb) Len() int      { return len(s) }
+func (s byProb) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byProb) Less(i, j int) bool {
+	if s[i].prob != s[j].prob {
+		return s[i].prob > s[j].prob
+	}
+	return s[i].word < s[j].word
+}
+
+// TopWords returns the n words of the given topic having the highest
+// P(w|z), in decreasing order of probability, together with their
+// probabilities. Fewer words are returned if the vocabulary is smaller
+// than n, and none if topicId is not in the model.
+func (model *Model) TopWords(topicId, n int) (words []string, probs []float64) {
+	if topicId < 0 || topicId >= len(model.wordTopicProb) || n <= 0 {
+		return nil, nil
+	}
+	ranked := make(byProb, len(model.vocab))
+	for w, p := range model.wordTopicProb[topicId] {
+		ranked[w] = wordProb{w, p}
+	}
+	sort.Sort(ranked)
+	if n > len(ranked) {
+		n = len(ranked)
+	}
+	for _, r := range ranked[:n] {
+		words = append(words, model.vocab[r.word])
+		probs = append(probs, r.prob)
+	}
+	return
+}
+
+// WriteTopWords writes the n words of each topic having the highest P(w|z)
+// in the z_top_w format read by kmean.PlsaSampleSupplier: one line per
+// topic, in order of topic id, holding the topic id and P(z) followed by
+// each word and its probability, all separated by a single space. If
+// renormalize is true, the probabilities of the n words are scaled to sum
+// to 1. Words containing white spaces cannot be written in this format and
+// cause an error.
+func (model *Model) WriteTopWords(w io.Writer, n int, renormalize bool) error {
+	writer := bufio.NewWriter(w)
+	for z, pz := range model.topicProb {
+		words, probs := model.TopWords(z, n)
+		total := float64(0)
+		for _, p := range probs {
+			total += p
+		}
+		fmt.Fprintf(writer, "%d %f ", z, pz)
+		for i, word := range words {
+			if word == "" || strings.IndexFunc(word, unicode.IsSpace) >= 0 {
+				return errors.New(fmt.Sprintf("word [%s] of topic %d contains white spaces", word, z))
+			}
+			p := probs[i]
+			if renormalize && total > 0 {
+				p /= total
+			}
+			fmt.Fprintf(writer, "%s %f ", word, p)
+		}
+		if _, err := writer.WriteString("\n"); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// SaveTopWordsToFile writes the top n words of each topic to the given
+// file, encoded in enc, as described in WriteTopWords.
+func (model *Model) SaveTopWordsToFile(filename string, n int, renormalize bool, enc charset.Encoding) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = model.WriteTopWords(charset.NewWriter(file, enc), n, renormalize)
+	if cErr := file.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
@@ -0,0 +1,274 @@
+// Copyright 2013 Weidong Liang. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plsa
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+)
+
+// TermRanking selects how VocabularyFilter ranks the words when keeping
+// only the top TopK of them.
+type TermRanking int
+
+const (
+	// ByFrequency ranks the words by their total count in the corpus.
+	ByFrequency TermRanking = iota
+	// ByTFIDF ranks the words by the sum over the documents of their term
+	// frequency, relative to the length of the document, times their
+	// inverse document frequency log(N/df).
+	ByTFIDF
+)
+
+// RemovalReason tells why VocabularyFilter removed a word.
+type RemovalReason int
+
+const (
+	Blocklisted RemovalReason = iota
+	BelowMinCount
+	AboveMaxDocRatio
+	BeyondTopK
+)
+
+func (r RemovalReason) String() string {
+	switch r {
+	case Blocklisted:
+		return "blocklisted"
+	case BelowMinCount:
+		return "below min count"
+	case AboveMaxDocRatio:
+		return "above max document ratio"
+	case BeyondTopK:
+		return "beyond top k"
+	}
+	return fmt.Sprintf("RemovalReason(%d)", int(r))
+}
+
+// RemovedWord describes a word removed by VocabularyFilter.
+type RemovedWord struct {
+	Word    string
+	Reason  RemovalReason
+	Count   uint64 // Total number of occurrences in the corpus.
+	DocFreq int    // Number of documents containing the word.
+}
+
+// VocabularyReport summarizes the result of VocabularyFilter.Apply.
+type VocabularyReport struct {
+	Words         int           // Number of words in the vocabulary of the source.
+	Kept          int           // Number of words kept.
+	RemovedTokens uint64        // Total count of the removed words.
+	Removed       []RemovedWord // Removed words, in the order of the source vocabulary.
+}
+
+func (r *VocabularyReport) String() string {
+	byReason := make(map[RemovalReason]int)
+	for _, w := range r.Removed {
+		byReason[w.Reason]++
+	}
+	return fmt.Sprintf("%d of %d words kept, %d tokens removed (%d %s, %d %s, %d %s, %d %s)",
+		r.Kept, r.Words, r.RemovedTokens,
+		byReason[Blocklisted], Blocklisted, byReason[BelowMinCount], BelowMinCount,
+		byReason[AboveMaxDocRatio], AboveMaxDocRatio, byReason[BeyondTopK], BeyondTopK)
+}
+
+// VocabularyFilter is a DocWordFreqRetriever presenting the documents of
+// another DocWordFreqRetriever restricted to a subset of its vocabulary.
+// Words are removed, in that order, if they match any of the Blocklist
+// patterns, if they occur fewer than MinCount times in the corpus, if they
+// appear in more than MaxDocRatio of the documents, and finally if they are
+// not among the TopK remaining words as ranked by Ranking. Zero values
+// disable the corresponding criterion.
+//
+// The filter is computed by Apply, or by LoadFromFile which loads the
+// source before applying the filter. Until then, the whole vocabulary of
+// the source is presented.
+type VocabularyFilter struct {
+	MinCount    uint64
+	MaxDocRatio float64
+	TopK        int
+	Ranking     TermRanking
+	Blocklist   []*regexp.Regexp
+	source      DocWordFreqRetriever
+	vocab       []string
+	kept        map[string]bool // nil until Apply, when all the words are kept
+	report      VocabularyReport
+}
+
+func NewVocabularyFilter(source DocWordFreqRetriever) *VocabularyFilter {
+	var filter VocabularyFilter
+	filter.source = source
+	return &filter
+}
+
+// LoadFromFile loads the source from the given file and applies the
+// filter.
+func (filter *VocabularyFilter) LoadFromFile(docWordFreqFile string) error {
+	if err := filter.source.LoadFromFile(docWordFreqFile); err != nil {
+		return err
+	}
+	filter.Apply()
+	log.Printf("VocabularyFilter.LoadFromFile(%s): %s", docWordFreqFile, &filter.report)
+	return nil
+}
+
+// Apply computes the filtered vocabulary from the current content of the
+// source.
+func (filter *VocabularyFilter) Apply() {
+	words := filter.source.Vocabulary()
+	wordIndex := make(map[string]int, len(words))
+	for i, w := range words {
+		wordIndex[w] = i
+	}
+	counts := make([]uint64, len(words))
+	docFreq := make([]int, len(words))
+	tfidf := make([]float64, len(words))
+	docWords := make([]int, 0)
+	docCounts := make([]uint64, 0)
+	docs := filter.source.CorpusIds()
+	for _, d := range docs {
+		docWords, docCounts = docWords[:0], docCounts[:0]
+		length := uint64(0)
+		forEachWordInDoc(filter.source, d, func(word string, count uint64) {
+			if w, found := wordIndex[word]; found && count > 0 {
+				docWords = append(docWords, w)
+				docCounts = append(docCounts, count)
+				length += count
+			}
+		})
+		for i, w := range docWords {
+			counts[w] += docCounts[i]
+			docFreq[w]++
+			tfidf[w] += float64(docCounts[i]) / float64(length)
+		}
+	}
+
+	var report VocabularyReport
+	report.Words = len(words)
+	removed := make(map[int]RemovalReason)
+	var candidates []int
+	for w, word := range words {
+		reason, remove := filter.check(word, counts[w], docFreq[w], len(docs))
+		if remove {
+			removed[w] = reason
+		} else {
+			candidates = append(candidates, w)
+		}
+	}
+	if filter.TopK > 0 && len(candidates) > filter.TopK {
+		score := func(w int) float64 {
+			if filter.Ranking == ByTFIDF {
+				// A word of the vocabulary may appear in no document.
+				if docFreq[w] == 0 {
+					return 0
+				}
+				return tfidf[w] * math.Log(float64(len(docs))/float64(docFreq[w]))
+			}
+			return float64(counts[w])
+		}
+		// Ties are broken by the order of the vocabulary.
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return score(candidates[i]) > score(candidates[j])
+		})
+		for _, w := range candidates[filter.TopK:] {
+			removed[w] = BeyondTopK
+		}
+	}
+
+	(*filter).vocab = nil
+	(*filter).kept = make(map[string]bool, len(words)-len(removed))
+	for w, word := range words {
+		if reason, found := removed[w]; found {
+			report.Removed = append(report.Removed, RemovedWord{word, reason, counts[w], docFreq[w]})
+			report.RemovedTokens += counts[w]
+			continue
+		}
+		(*filter).vocab = append((*filter).vocab, word)
+		(*filter).kept[word] = true
+	}
+	report.Kept = len(filter.vocab)
+	(*filter).report = report
+}
+
+func (filter *VocabularyFilter) check(word string, count uint64, docFreq, numDocs int) (RemovalReason, bool) {
+	for _, pattern := range filter.Blocklist {
+		if pattern.MatchString(word) {
+			return Blocklisted, true
+		}
+	}
+	if count < filter.MinCount {
+		return BelowMinCount, true
+	}
+	if filter.MaxDocRatio > 0 && float64(docFreq) > filter.MaxDocRatio*float64(numDocs) {
+		return AboveMaxDocRatio, true
+	}
+	return 0, false
+}
+
+// forEachWordInDoc enumerates the words of the given document, using the
+// DocWordIterator of docWordFreq if it implements one.
+func forEachWordInDoc(docWordFreq DocWordFreqRetriever, docId string, processor func(word string, count uint64)) {
+	if iterator, ok := docWordFreq.(DocWordIterator); ok {
+		iterator.ForEachWordInDoc(docId, processor)
+		return
+	}
+	for _, w := range docWordFreq.Vocabulary() {
+		processor(w, docWordFreq.DocWordCount(docId, w))
+	}
+}
+
+// Report returns the summary of the last call to Apply.
+func (filter *VocabularyFilter) Report() *VocabularyReport {
+	return &(*filter).report
+}
+
+func (filter *VocabularyFilter) CorpusIds() []string {
+	return filter.source.CorpusIds()
+}
+
+func (filter *VocabularyFilter) CorpusSize() int {
+	return filter.source.CorpusSize()
+}
+
+func (filter *VocabularyFilter) Vocabulary() []string {
+	if filter.kept == nil {
+		return filter.source.Vocabulary()
+	}
+	return (*filter).vocab
+}
+
+func (filter *VocabularyFilter) VocabularySize() int {
+	if filter.kept == nil {
+		return filter.source.VocabularySize()
+	}
+	return len((*filter).vocab)
+}
+
+func (filter *VocabularyFilter) DocWordCount(docId, word string) uint64 {
+	if filter.kept != nil && !filter.kept[word] {
+		return 0
+	}
+	return filter.source.DocWordCount(docId, word)
+}
+
+// ForEachWordInDoc calls processor with each kept word of the given
+// document that has a non-zero count.
+func (filter *VocabularyFilter) ForEachWordInDoc(docId string, processor func(word string, count uint64)) {
+	if _, ok := filter.source.(DocWordIterator); !ok {
+		for _, w := range filter.Vocabulary() {
+			if count := filter.source.DocWordCount(docId, w); count > 0 {
+				processor(w, count)
+			}
+		}
+		return
+	}
+	forEachWordInDoc(filter.source, docId, func(word string, count uint64) {
+		if filter.kept == nil || filter.kept[word] {
+			processor(word, count)
+		}
+	})
+}